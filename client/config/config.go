@@ -0,0 +1,380 @@
+// Package config loads the settings of the node from config.json in the
+// configuration directory, environment variables and command-line flags,
+// each overriding the previous ones:
+//
+//	{
+//	  "servers": ["proxy.example.com:8443", "backup.example.com:8443"],
+//	  "dashboard": "https://turbo-node.vercel.app",
+//	  "tls_pin": "sha256/<base64 SHA-256 of the server public key>",
+//	  "tls_verify": false,
+//	  "log_level": "info",
+//	  "autostart": true,
+//	  "autoupdate": true,
+//	  "headless": false,
+//	  "control_addr": "127.0.0.1:7770",
+//	  "sharing": {
+//	    "daily_cap": "2GB",
+//	    "monthly_cap": "50GB",
+//	    "upload_rate": "1MB",
+//	    "download_rate": "5MB",
+//	    "schedule": ["09:00-17:30", "22:00-06:00"]
+//	  }
+//	}
+//
+// Every setting has a flag of the same name, with dashes instead of
+// underscores, and a TURBO_ environment variable, such as -daily-cap and
+// TURBO_DAILY_CAP. Lists are comma-separated outside of the file.
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type Config struct {
+	// Servers are tried in order until one accepts the connection
+	Servers   []string `json:"servers"`
+	Dashboard string   `json:"dashboard"`
+	// TLSPin is the SHA-256 of the public key of the servers. The
+	// self-signed certificate servers generate by default requires it.
+	TLSPin string `json:"tls_pin"`
+	// TLSVerify verifies servers without a pin against the system roots.
+	// Releases before tls_pin never verified servers, so until every node
+	// has a pin it is off and unpinned servers are trusted with a warning.
+	TLSVerify bool `json:"tls_verify"`
+	// TLSInsecure skips the verification of the servers altogether
+	TLSInsecure bool   `json:"tls_insecure"`
+	LogLevel    string `json:"log_level"`
+	AutoStart   bool   `json:"autostart"`
+	AutoUpdate  bool   `json:"autoupdate"`
+	// Headless runs the node without the tray icon, pairing with a code
+	Headless bool `json:"headless"`
+	// ControlAddr is the loopback address of the control API, which is
+	// disabled when empty
+	ControlAddr string  `json:"control_addr"`
+	Sharing     Sharing `json:"sharing"`
+
+	pin   []byte
+	level slog.Level
+}
+
+// Sharing limits what the node shares. Caps are in bytes relayed in both
+// directions, counted per calendar day and month of the local time.
+// UploadRate bounds the bytes per second sent to targets and DownloadRate
+// the bytes per second received from them. The node only shares during the
+// Schedule windows, in local time, a window ending before it starts spans
+// midnight. Zero values are unlimited and an empty schedule shares all day.
+type Sharing struct {
+	DailyCap     Size     `json:"daily_cap"`
+	MonthlyCap   Size     `json:"monthly_cap"`
+	UploadRate   Size     `json:"upload_rate"`
+	DownloadRate Size     `json:"download_rate"`
+	Schedule     []string `json:"schedule"`
+
+	// Windows are the parsed Schedule
+	Windows []Window `json:"-"`
+}
+
+var Default = Config{
+	Servers:     []string{"192.168.1.144:8443"},
+	Dashboard:   "https://turbo-node.vercel.app",
+	LogLevel:    "info",
+	AutoStart:   true,
+	AutoUpdate:  true,
+	ControlAddr: "127.0.0.1:7770",
+}
+
+// Path returns the path of name in the configuration directory of the node
+func Path(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "Turbo", name), nil
+}
+
+// setting is a config.json entry that can be overridden by an environment
+// variable and a flag
+type setting struct {
+	name  string
+	usage string
+	set   func(c *Config, value string) error
+	bool  bool
+}
+
+var settings = []setting{
+	{name: "servers", usage: "comma-separated server addresses, tried in order", set: func(c *Config, v string) error {
+		c.Servers = splitList(v)
+		return nil
+	}},
+	{name: "dashboard", usage: "URL of the dashboard", set: func(c *Config, v string) error {
+		c.Dashboard = v
+		return nil
+	}},
+	{name: "tls_pin", usage: "sha256/<base64> hash of the public key of the servers", set: func(c *Config, v string) error {
+		c.TLSPin = v
+		return nil
+	}},
+	{name: "tls_verify", usage: "verify servers without tls_pin against the system roots", bool: true, set: func(c *Config, v string) error {
+		return parseBool(&c.TLSVerify, v)
+	}},
+	{name: "tls_insecure", usage: "skip the verification of the servers", bool: true, set: func(c *Config, v string) error {
+		return parseBool(&c.TLSInsecure, v)
+	}},
+	{name: "log_level", usage: "debug, info, warn or error", set: func(c *Config, v string) error {
+		c.LogLevel = v
+		return nil
+	}},
+	{name: "autostart", usage: "start the node with the system", bool: true, set: func(c *Config, v string) error {
+		return parseBool(&c.AutoStart, v)
+	}},
+	{name: "autoupdate", usage: "install new releases", bool: true, set: func(c *Config, v string) error {
+		return parseBool(&c.AutoUpdate, v)
+	}},
+	{name: "headless", usage: "run without the tray icon, as on servers", bool: true, set: func(c *Config, v string) error {
+		return parseBool(&c.Headless, v)
+	}},
+	{name: "control_addr", usage: "loopback address of the control API, empty to disable it", set: func(c *Config, v string) error {
+		c.ControlAddr = v
+		return nil
+	}},
+	{name: "daily_cap", usage: "bytes shared per day, such as 2GB", set: func(c *Config, v string) error {
+		return c.Sharing.DailyCap.parse(v)
+	}},
+	{name: "monthly_cap", usage: "bytes shared per month, such as 50GB", set: func(c *Config, v string) error {
+		return c.Sharing.MonthlyCap.parse(v)
+	}},
+	{name: "upload_rate", usage: "bytes per second sent to targets, such as 1MB", set: func(c *Config, v string) error {
+		return c.Sharing.UploadRate.parse(v)
+	}},
+	{name: "download_rate", usage: "bytes per second received from targets, such as 5MB", set: func(c *Config, v string) error {
+		return c.Sharing.DownloadRate.parse(v)
+	}},
+	{name: "schedule", usage: "comma-separated HH:MM-HH:MM sharing windows", set: func(c *Config, v string) error {
+		c.Sharing.Schedule = splitList(v)
+		return nil
+	}},
+}
+
+func (s setting) flag() string {
+	return strings.ReplaceAll(s.name, "_", "-")
+}
+
+func (s setting) env() string {
+	return "TURBO_" + strings.ToUpper(s.name)
+}
+
+// Load reads the configuration from the file given by -config or
+// TURBO_CONFIG, config.json by default, then applies the environment and
+// args. Every invalid setting is reported in the returned error.
+func Load(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("turbo", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv("TURBO_CONFIG"), "path of the configuration file")
+
+	type override struct {
+		setting setting
+		value   string
+	}
+	var overrides []override
+	for _, s := range settings {
+		record := func(value string) error {
+			overrides = append(overrides, override{s, value})
+			return nil
+		}
+		usage := s.usage + " (" + s.env() + ")"
+		if s.bool {
+			flags.BoolFunc(s.flag(), usage, record)
+		} else {
+			flags.Func(s.flag(), usage, record)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	c := Default
+	c.Servers = slices.Clone(Default.Servers)
+	if err := c.loadFile(*path); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env()); ok {
+			if err := s.set(&c, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env(), err))
+			}
+		}
+	}
+	for _, o := range overrides {
+		if err := o.setting.set(&c, o.value); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", o.setting.flag(), err))
+		}
+	}
+	if err := c.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &c, nil
+}
+
+// loadFile applies the file at path, the default file may not exist
+func (c *Config) loadFile(path string) error {
+	explicit := path != ""
+	if !explicit {
+		var err error
+		if path, err = Path("config.json"); err != nil {
+			return nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading configuration: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("invalid configuration %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks every setting and reports all the invalid ones
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(name, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{name}, args...)...))
+	}
+
+	if len(c.Servers) == 0 {
+		invalid("servers", "at least one server is required")
+	}
+	for _, server := range c.Servers {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			invalid("servers", "%q is not a host:port address", server)
+			continue
+		}
+		if n, err := strconv.Atoi(port); host == "" || err != nil || n < 1 || n > 65535 {
+			invalid("servers", "%q is not a host:port address", server)
+		}
+	}
+
+	if u, err := url.Parse(c.Dashboard); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		invalid("dashboard", "%q is not an http(s) URL", c.Dashboard)
+	}
+	c.Dashboard = strings.TrimSuffix(c.Dashboard, "/")
+
+	c.pin = nil
+	if c.TLSPin != "" {
+		hash, ok := strings.CutPrefix(c.TLSPin, "sha256/")
+		pin, err := base64.StdEncoding.DecodeString(hash)
+		if !ok || err != nil || len(pin) != 32 {
+			invalid("tls_pin", "%q is not a sha256/<base64> public key hash", c.TLSPin)
+		}
+		c.pin = pin
+		if c.TLSInsecure {
+			invalid("tls_insecure", "cannot be combined with tls_pin")
+		}
+	}
+	if c.TLSInsecure && c.TLSVerify {
+		invalid("tls_insecure", "cannot be combined with tls_verify")
+	}
+
+	if c.ControlAddr != "" && !isLoopback(c.ControlAddr) {
+		invalid("control_addr", "%q is not a loopback host:port address", c.ControlAddr)
+	}
+
+	if err := c.level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		invalid("log_level", "%q is not one of debug, info, warn or error", c.LogLevel)
+	}
+
+	sizes := []struct {
+		name string
+		size Size
+	}{
+		{"daily_cap", c.Sharing.DailyCap},
+		{"monthly_cap", c.Sharing.MonthlyCap},
+		{"upload_rate", c.Sharing.UploadRate},
+		{"download_rate", c.Sharing.DownloadRate},
+	}
+	for _, s := range sizes {
+		if s.size < 0 {
+			invalid(s.name, "cannot be negative")
+		}
+	}
+	windows, err := parseSchedule(c.Sharing.Schedule)
+	if err != nil {
+		invalid("schedule", "%v", err)
+	}
+	c.Sharing.Windows = windows
+
+	return errors.Join(errs...)
+}
+
+// Pin returns the decoded TLSPin, nil when there is none
+func (c *Config) Pin() []byte {
+	return c.pin
+}
+
+func (c *Config) Level() slog.Level {
+	return c.level
+}
+
+// isLoopback tells whether addr is a host:port address only reachable from
+// the machine
+func isLoopback(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func parseBool(b *bool, value string) error {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", value)
+	}
+	*b = v
+	return nil
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// validPin is the sha256/<base64> pin of 32 zero bytes
+const validPin = "sha256/AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+func TestValidate(t *testing.T) {
+	c := Default
+	c.Dashboard = "https://dashboard.example/"
+	c.TLSPin = validPin
+	c.Sharing.Schedule = []string{"22:00-06:00"}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Dashboard != "https://dashboard.example" {
+		t.Errorf("dashboard %q kept its trailing slash", c.Dashboard)
+	}
+	if len(c.Pin()) != 32 {
+		t.Errorf("pin of %d bytes, want 32", len(c.Pin()))
+	}
+	if !slices.Equal(c.Sharing.Windows, []Window{{22 * 60, 6 * 60}}) {
+		t.Errorf("schedule parsed to %v", c.Sharing.Windows)
+	}
+}
+
+func TestValidateInvalid(t *testing.T) {
+	tests := []struct {
+		setting string
+		modify  func(c *Config)
+	}{
+		{"servers", func(c *Config) { c.Servers = nil }},
+		{"servers", func(c *Config) { c.Servers = []string{"proxy.example.com"} }},
+		{"servers", func(c *Config) { c.Servers = []string{":8443"} }},
+		{"servers", func(c *Config) { c.Servers = []string{"proxy.example.com:0"} }},
+		{"servers", func(c *Config) { c.Servers = []string{"proxy.example.com:65536"} }},
+		{"dashboard", func(c *Config) { c.Dashboard = "turbo-node.vercel.app" }},
+		{"dashboard", func(c *Config) { c.Dashboard = "ftp://turbo-node.vercel.app" }},
+		{"tls_pin", func(c *Config) { c.TLSPin = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=" }},
+		{"tls_pin", func(c *Config) { c.TLSPin = "sha256/AAAA" }},
+		{"tls_pin", func(c *Config) { c.TLSPin = "sha256/not base64" }},
+		{"tls_insecure", func(c *Config) { c.TLSPin, c.TLSInsecure = validPin, true }},
+		{"tls_insecure", func(c *Config) { c.TLSVerify, c.TLSInsecure = true, true }},
+		{"control_addr", func(c *Config) { c.ControlAddr = "0.0.0.0:7770" }},
+		{"control_addr", func(c *Config) { c.ControlAddr = "192.168.1.2:7770" }},
+		{"log_level", func(c *Config) { c.LogLevel = "verbose" }},
+		{"daily_cap", func(c *Config) { c.Sharing.DailyCap = -1 }},
+		{"monthly_cap", func(c *Config) { c.Sharing.MonthlyCap = -1 }},
+		{"upload_rate", func(c *Config) { c.Sharing.UploadRate = -1 }},
+		{"download_rate", func(c *Config) { c.Sharing.DownloadRate = -1 }},
+		{"schedule", func(c *Config) { c.Sharing.Schedule = []string{"9-5"} }},
+	}
+
+	for _, tt := range tests {
+		c := Default
+		c.Servers = slices.Clone(Default.Servers)
+		tt.modify(&c)
+
+		err := c.Validate()
+		if err == nil || !strings.HasPrefix(err.Error(), tt.setting+": ") {
+			t.Errorf("%s: got %v, want an error for the setting", tt.setting, err)
+		}
+	}
+}
+
+func TestValidateReportsEverySetting(t *testing.T) {
+	c := Default
+	c.Servers = nil
+	c.LogLevel = "verbose"
+	c.Sharing.DailyCap = -1
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, setting := range []string{"servers", "log_level", "daily_cap"} {
+		if !strings.Contains(err.Error(), setting+": ") {
+			t.Errorf("error does not report %s: %v", setting, err)
+		}
+	}
+}
+
+func TestControlAddr(t *testing.T) {
+	for _, addr := range []string{"", "127.0.0.1:7770", "[::1]:7770", "localhost:7770", "127.0.0.1:0"} {
+		c := Default
+		c.ControlAddr = addr
+		if err := c.Validate(); err != nil {
+			t.Errorf("control_addr %q refused: %v", addr, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a sharing window in minutes since midnight
+type Window struct {
+	Start, End int
+}
+
+func (w Window) Contains(minute int) bool {
+	if w.Start <= w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+func parseSchedule(entries []string) ([]Window, error) {
+	var windows []Window
+	for _, entry := range entries {
+		from, to, ok := strings.Cut(entry, "-")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a HH:MM-HH:MM window", entry)
+		}
+
+		start, err1 := parseClock(from)
+		end, err2 := parseClock(to)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%q is not a HH:MM-HH:MM window", entry)
+		}
+		if start == end {
+			return nil, fmt.Errorf("window %q is empty", entry)
+		}
+		windows = append(windows, Window{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+// parseClock returns the minutes since midnight of HH:MM, 24:00 being the
+// end of the day
+func parseClock(clock string) (int, error) {
+	clock = strings.TrimSpace(clock)
+	if clock == "24:00" {
+		return 24 * 60, nil
+	}
+
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseSchedule(t *testing.T) {
+	windows, err := parseSchedule([]string{"09:00-17:30", " 22:00 - 06:00 ", "00:00-24:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Window{{9 * 60, 17*60 + 30}, {22 * 60, 6 * 60}, {0, 24 * 60}}
+	if !slices.Equal(windows, want) {
+		t.Fatalf("got %v, want %v", windows, want)
+	}
+
+	for _, entry := range []string{"", "09:00", "09:00-", "9h-17h", "25:00-26:00", "09:60-10:00", "10:00-10:00", "24:00-24:00"} {
+		if windows, err := parseSchedule([]string{entry}); err == nil {
+			t.Errorf("parseSchedule(%q) = %v, want an error", entry, windows)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	day := Window{Start: 9 * 60, End: 17*60 + 30}
+	night := Window{Start: 22 * 60, End: 6 * 60} // spans midnight
+	allDay := Window{Start: 0, End: 24 * 60}
+
+	tests := []struct {
+		window Window
+		minute int
+		want   bool
+	}{
+		{day, 9 * 60, true},
+		{day, 12 * 60, true},
+		{day, 17*60 + 29, true},
+		{day, 17*60 + 30, false},
+		{day, 8*60 + 59, false},
+		{night, 22 * 60, true},
+		{night, 23*60 + 59, true},
+		{night, 0, true},
+		{night, 6*60 - 1, true},
+		{night, 6 * 60, false},
+		{night, 12 * 60, false},
+		{allDay, 0, true},
+		{allDay, 24*60 - 1, true},
+	}
+	for _, tt := range tests {
+		if got := tt.window.Contains(tt.minute); got != tt.want {
+			t.Errorf("%v.Contains(%d) = %v, want %v", tt.window, tt.minute, got, tt.want)
+		}
+	}
+}
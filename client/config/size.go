@@ -0,0 +1,56 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Size is a number of bytes, written as a number or a string with a KB, MB,
+// GB or TB suffix, in powers of 1000
+type Size int64
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"TB", 1e12},
+	{"GB", 1e9},
+	{"MB", 1e6},
+	{"KB", 1e3},
+	{"B", 1},
+}
+
+func (s *Size) parse(text string) error {
+	value := strings.ToUpper(strings.TrimSpace(text))
+
+	multiplier := 1.0
+	for _, unit := range sizeUnits {
+		if number, ok := strings.CutSuffix(value, unit.suffix); ok {
+			value, multiplier = strings.TrimSpace(number), unit.bytes
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return fmt.Errorf("%q is not a size such as 500MB", text)
+	}
+	*s = Size(n * multiplier)
+	return nil
+}
+
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("%s is not a size such as 500MB", data)
+		}
+		*s = Size(n)
+		return nil
+	}
+	return s.parse(value)
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSizeUnmarshal(t *testing.T) {
+	tests := []struct {
+		json string
+		want Size
+	}{
+		{`1024`, 1024},
+		{`"1024"`, 1024},
+		{`"500B"`, 500},
+		{`"2KB"`, 2000},
+		{`"1.5MB"`, 1_500_000},
+		{`"2 gb"`, 2_000_000_000},
+		{`" 50GB "`, 50_000_000_000},
+		{`"1TB"`, 1_000_000_000_000},
+		{`"0"`, 0},
+		{`"-1MB"`, -1_000_000}, // refused by Validate
+	}
+	for _, tt := range tests {
+		var s Size
+		if err := json.Unmarshal([]byte(tt.json), &s); err != nil || s != tt.want {
+			t.Errorf("unmarshal %s = %d, %v, want %d", tt.json, s, err, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{`""`, `"MB"`, `"5 MiB"`, `"five"`, `"NaN"`, `"Inf"`, `1.5`, `true`} {
+		var s Size
+		if err := json.Unmarshal([]byte(invalid), &s); err == nil {
+			t.Errorf("unmarshal %s = %d, want an error", invalid, s)
+		}
+	}
+}
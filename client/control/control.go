@@ -0,0 +1,130 @@
+// Package control serves the local API the runner, scripts and the tray use
+// to follow and steer the node. It only listens on loopback and every
+// request carries the token saved in control_token, in the configuration
+// directory:
+//
+//	GET  /status  state of the node, see quic.Status
+//	POST /pause   stop taking new connections
+//	POST /resume  take new connections again
+//	POST /update  look for a new release, installing it restarts the node
+//
+// For example:
+//
+//	curl -H "Authorization: Bearer $(cat ~/.config/Turbo/control_token)" http://127.0.0.1:7770/status
+package control
+
+import (
+	"client/config"
+	"client/quic"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// UpdateFunc looks for a new release and tells whether it was installed
+type UpdateFunc func() (updated bool, err error)
+
+// Serve runs the API on addr until ctx is done
+func Serve(ctx context.Context, addr string, checkUpdate UpdateFunc) error {
+	token, err := loadToken()
+	if err != nil {
+		return fmt.Errorf("loading control token: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, quic.CurrentStatus())
+	})
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		quic.Pause()
+		writeJSON(w, quic.CurrentStatus())
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		quic.Resume()
+		writeJSON(w, quic.CurrentStatus())
+	})
+	mux.HandleFunc("POST /update", func(w http.ResponseWriter, r *http.Request) {
+		updated, err := checkUpdate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, map[string]bool{"updated": updated})
+	})
+
+	server := &http.Server{
+		Handler:           authorize(token, mux),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	context.AfterFunc(ctx, func() { server.Close() })
+
+	slog.Info("Control API listening", "addr", listener.Addr())
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// authorize only lets requests with the token through. Browsers cannot send
+// the header to another origin without a preflight, which is not answered.
+func authorize(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// loadToken reads the token of the API, generating it on first use so that
+// scripts keep working across restarts
+func loadToken() (string, error) {
+	path, err := config.Path("control_token")
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	token := hex.EncodeToString(secret)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+	return token, nil
+}
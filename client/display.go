@@ -0,0 +1,11 @@
+//go:build !headless && !windows && !darwin
+
+package main
+
+import "os"
+
+// hasDisplay tells whether an X11 or Wayland session is available for the
+// tray icon
+func hasDisplay() bool {
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
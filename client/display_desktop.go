@@ -0,0 +1,9 @@
+//go:build !headless && (windows || darwin)
+
+package main
+
+// hasDisplay is always true on Windows and macOS, where the node runs in the
+// desktop session of the user
+func hasDisplay() bool {
+	return true
+}
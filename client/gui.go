@@ -0,0 +1,47 @@
+//go:build !headless
+
+package main
+
+import (
+	"client/config"
+	"client/quic"
+	"client/ui"
+	"context"
+	_ "embed"
+
+	"github.com/getlantern/systray"
+)
+
+//go:embed assets/tray_icon.ico
+var iconData []byte
+
+func guiAvailable() bool {
+	return hasDisplay()
+}
+
+// runTray runs the node behind the tray icon until ctx is done or the user
+// quits from the tray
+func runTray(ctx context.Context, cfg *config.Config) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		quic.ConnectQuicServer(ctx, cfg, installUpdate)
+		close(done)
+		systray.Quit()
+	}()
+
+	systray.Run(func() { onReady(cfg) }, nil)
+	cancel()
+	<-done
+}
+
+func onReady(cfg *config.Config) {
+	ui.SetupTray(cfg.Dashboard, iconData)
+
+	if cfg.AutoStart {
+		enableAutoStart()
+	}
+	if cfg.AutoUpdate {
+		autoUpdate()
+	}
+}
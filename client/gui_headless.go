@@ -0,0 +1,19 @@
+//go:build headless
+
+package main
+
+import (
+	"client/config"
+	"context"
+)
+
+// Builds with the headless tag leave out the tray and its cgo dependencies,
+// they always run headless.
+
+func guiAvailable() bool {
+	return false
+}
+
+func runTray(ctx context.Context, cfg *config.Config) {
+	runHeadless(ctx, cfg)
+}
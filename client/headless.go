@@ -0,0 +1,41 @@
+package main
+
+import (
+	"client/config"
+	"client/quic"
+	"context"
+	"time"
+)
+
+// updateInterval is how often headless nodes look for a new release
+const updateInterval = 24 * time.Hour
+
+// runHeadless runs the node until ctx is done, pairing is done with the code
+// it prints. Installing an update shuts the node down gracefully so that
+// main restarts it on the new version.
+func runHeadless(ctx context.Context, cfg *config.Config) {
+	if cfg.AutoStart {
+		enableAutoStart()
+	}
+
+	if cfg.AutoUpdate {
+		go func() {
+			ticker := time.NewTicker(updateInterval)
+			defer ticker.Stop()
+
+			for {
+				if installed, _ := installUpdate(); installed {
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+
+	quic.ConnectQuicServer(ctx, cfg, installUpdate)
+}
@@ -1,41 +1,107 @@
 package main
 
 import (
+	"client/config"
+	"client/control"
 	"client/platform/autostart"
 	"client/platform/update"
 	"client/quic"
-	"client/ui"
-	_ "embed"
-	"log"
-
-	"github.com/getlantern/systray"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 )
 
-//go:embed assets/tray_icon.ico
-var iconData []byte
+func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetLogLoggerLevel(cfg.Level())
 
-const (
-	WEBSITE = "https://turbo-node.vercel.app"
-)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, stopNode = context.WithCancel(ctx)
 
-func main() {
-	go quic.ConnectQuicServer()
+	if !cfg.Headless && !guiAvailable() {
+		slog.Info("No display available, running headless")
+		cfg.Headless = true
+	}
 
-	systray.Run(onReady, nil)
-}
+	if cfg.ControlAddr != "" {
+		go func() {
+			if err := control.Serve(ctx, cfg.ControlAddr, installUpdate); err != nil {
+				slog.Warn("Control API unavailable", "err", err)
+			}
+		}()
+	}
 
-func onReady() {
-	ui.SetupTray(WEBSITE, iconData)
+	if cfg.Headless {
+		runHeadless(ctx, cfg)
+	} else {
+		runTray(ctx, cfg)
+	}
 
+	if updated.Load() {
+		slog.Info("Updated, restarting")
+		if err := update.Restart(); err != nil {
+			slog.Error("Failed to restart", "err", err)
+		}
+	}
+}
+
+func enableAutoStart() {
 	if err := autostart.EnableAutoStart(); err != nil {
-		log.Println(err)
+		slog.Warn("Failed to enable autostart", "err", err)
 	}
+}
 
-	if err := update.AutoUpdate(); err != nil {
-		log.Println(err)
+// autoUpdate installs the latest release and tells whether it did, failures
+// are reported to the server
+func autoUpdate() (bool, error) {
+	updated, err := update.AutoUpdate()
+	if err != nil {
+		slog.Error("Auto-update failed", "err", err)
 		quic.SendMessage(&quic.Message{
-			Type: "stacktrace",
-			Data: "Auto-update failed: " + err.Error(),
+			Type: quic.MsgStacktrace,
+			Data: []byte("Auto-update failed: " + err.Error()),
 		})
 	}
+	return updated, err
+}
+
+var (
+	// stopNode stops the node gracefully, main then restarts it if updated
+	// is set
+	stopNode    context.CancelFunc
+	updated     atomic.Bool
+	updateMutex sync.Mutex
+)
+
+// installUpdate installs the latest release and stops the node to restart
+// it on the new version
+func installUpdate() (bool, error) {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	if updated.Load() {
+		return true, nil
+	}
+	installed, err := autoUpdate()
+	if installed {
+		updated.Store(true)
+		stopNode()
+	}
+	return installed, err
 }
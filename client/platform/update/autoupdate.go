@@ -24,7 +24,10 @@ type GitHubRelease struct {
 
 const url = "https://api.github.com/repos/L1shed/Turbo/releases/latest"
 
-func AutoUpdate() error {
+// AutoUpdate installs the latest release if it is newer, updated tells
+// whether the executable was replaced. The new version runs from the next
+// start, see Restart.
+func AutoUpdate() (updated bool, err error) {
 	client := http.Client{
 		Timeout: 10 * time.Second,
 	}
@@ -32,29 +35,29 @@ func AutoUpdate() error {
 	release, hasUpdate, err := checkForUpdate(client)
 	if err != nil {
 		if strings.Contains(err.Error(), "404") {
-			return nil // No release yet
+			return false, nil // No release yet
 		}
-		return fmt.Errorf("checking for updates: %w", err)
+		return false, fmt.Errorf("checking for updates: %w", err)
 	}
 	if !hasUpdate {
-		return nil
+		return false, nil
 	}
 
 	assetURL, err := findAssetForPlatform(release)
 	if err != nil {
-		return fmt.Errorf("finding asset url: %w", err)
+		return false, fmt.Errorf("finding asset url: %w", err)
 	}
 
 	assetData, err := downloadUpdate(client, assetURL)
 	if err != nil {
-		return fmt.Errorf("downloading update: %w", err)
+		return false, fmt.Errorf("downloading update: %w", err)
 	}
 
 	if err := replaceExecutable(assetData); err != nil {
-		return fmt.Errorf("replacing executable: %w", err)
+		return false, fmt.Errorf("replacing executable: %w", err)
 	}
 
-	return nil
+	return true, nil
 }
 
 func checkForUpdate(client http.Client) (*GitHubRelease, bool, error) {
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package update
+
+import (
+	"os"
+	"syscall"
+)
+
+// Restart replaces the process with the executable, which runs the new
+// version once AutoUpdate installed it
+func Restart() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(executable, os.Args, os.Environ())
+}
@@ -0,0 +1,23 @@
+package update
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Restart starts the executable again and exits, which runs the new version
+// once AutoUpdate installed it
+func Restart() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
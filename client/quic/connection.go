@@ -1,32 +1,117 @@
 package quic
 
 import (
-	"encoding/base64"
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
 )
 
-func handleConnect(msg Message) {
-	conn, err := net.Dial("tcp", msg.Addr)
+// acceptStreams serves the streams opened by the server, one per user connection
+func acceptStreams(conn *quic.Conn) {
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+
+		go handleStream(stream)
+	}
+}
+
+func handleStream(stream *quic.Stream) {
+	msg, err := readFrame(stream)
+	if err != nil || msg.Type != MsgConnect {
+		slog.Warn("Invalid connection stream header", "err", err)
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+		return
+	}
+
+	handleConnect(stream, msg)
+}
+
+// activeConns counts the relays in progress
+var activeConns atomic.Int32
+
+// dialTimeout stays within the time the server waits for a connected frame
+const dialTimeout = 5 * time.Second
+
+func handleConnect(stream *quic.Stream, msg Message) {
+	slog.Debug("Connecting", "addr", msg.Addr)
+	if !isSharing() {
+		rejectConnect(stream, msg.ID, ConnectUnavailable)
+		return
+	}
+
+	conn, err := dialer.Dial("tcp", msg.Addr)
 	if err != nil || conn == nil {
-		log.Printf("Failed to connect to %s : %v", msg.Addr, err)
-		sendCloseMessage(msg.ID)
+		slog.Info("Failed to connect", "addr", msg.Addr, "err", err)
+		rejectConnect(stream, msg.ID, connectFailure(err))
 		return
 	}
 
-	data, _ := base64.StdEncoding.DecodeString(msg.Data)
-	_, err = conn.Write(data)
-	if err != nil {
+	confirm, _ := appendFrame(nil, &Message{Type: MsgConnected, ID: msg.ID})
+	if _, err := stream.Write(confirm); err != nil {
+		conn.Close()
+		stream.CancelRead(0)
 		return
 	}
 
-	dataChan := make(chan []byte, 100)
-	cc := &Connection{conn: conn, dataChan: dataChan}
+	if len(msg.Data) > 0 {
+		if _, err := conn.Write(msg.Data); err != nil {
+			conn.Close()
+			stream.CancelRead(0)
+			stream.CancelWrite(0)
+			return
+		}
+	}
 
-	clientMutex.Lock()
-	clientConns[msg.ID] = cc
-	clientMutex.Unlock()
+	activeConns.Add(1)
+	defer activeConns.Add(-1)
+	relay(conn, stream)
+}
+
+// Reasons sent in connect_error frames
+const (
+	ConnectRefused     = "refused"
+	ConnectUnreachable = "unreachable"
+	ConnectDNS         = "dns"
+	ConnectTimeout     = "timeout"
+	ConnectDenied      = "denied"
+	ConnectFailed      = "failed"
+	// ConnectUnavailable is sent while the node does not share, which the
+	// server may not know yet
+	ConnectUnavailable = "unavailable"
+)
+
+func connectFailure(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errLocalAddress):
+		return ConnectDenied
+	case errors.As(err, &dnsErr):
+		return ConnectDNS
+	case isRefused(err):
+		return ConnectRefused
+	case isUnreachable(err):
+		return ConnectUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ConnectTimeout
+	default:
+		return ConnectFailed
+	}
+}
 
-	go relayFromConnToQuic(cc, msg.ID)
-	go relayFromChanToConn(cc, msg.ID)
+// rejectConnect tells the server why the connection failed and ends the stream
+func rejectConnect(stream *quic.Stream, id uint32, reason string) {
+	frame, _ := appendFrame(nil, &Message{Type: MsgConnectError, ID: id, Data: []byte(reason)})
+	stream.Write(frame)
+	stream.CancelRead(0)
+	stream.Close()
 }
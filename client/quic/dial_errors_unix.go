@@ -0,0 +1,16 @@
+//go:build !windows
+
+package quic
+
+import (
+	"errors"
+	"syscall"
+)
+
+func isRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func isUnreachable(err error) bool {
+	return errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EHOSTUNREACH)
+}
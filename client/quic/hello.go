@@ -0,0 +1,129 @@
+package quic
+
+import (
+	"bufio"
+	"client/platform/update"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// ProtocolVersion must match a version accepted by the server
+const ProtocolVersion = 3
+
+// Capabilities advertised to the server in the hello message
+const (
+	CapabilityTCP = "tcp"
+	CapabilityUDP = "udp"
+)
+
+const (
+	RejectOutdated     = "protocol_outdated"
+	RejectUnauthorized = "unauthorized"
+)
+
+type NodeInfo struct {
+	Protocol     int      `json:"protocol"`
+	Version      string   `json:"version,omitempty"`
+	OS           string   `json:"os,omitempty"`
+	Arch         string   `json:"arch,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// Hello carries the identity of paired nodes along with NodeInfo, Session
+// is issued by the server and lets a node that briefly lost its connection
+// keep its metrics.
+type Hello struct {
+	NodeInfo
+	NodeID  string `json:"node_id,omitempty"`
+	Token   string `json:"token,omitempty"`
+	Session string `json:"session,omitempty"`
+}
+
+// session of the last connection, only used by ConnectQuicServer
+var session string
+
+type Reject struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// RejectError is returned when the server refuses the node
+type RejectError Reject
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("rejected by server (%s): %s", e.Code, e.Reason)
+}
+
+// handshake announces the node to the server and waits for its answer
+func handshake(stream *quic.Stream, reader *bufio.Reader) (*Hello, error) {
+	hello := Hello{
+		NodeInfo: NodeInfo{
+			Protocol:     ProtocolVersion,
+			Version:      update.VERSION,
+			OS:           runtime.GOOS,
+			Arch:         runtime.GOARCH,
+			Capabilities: []string{CapabilityTCP, CapabilityUDP},
+		},
+	}
+	if id := currentIdentity(); id != nil {
+		hello.NodeID = id.NodeID
+		hello.Token = id.Token
+		hello.Session = session
+	}
+
+	payload, _ := json.Marshal(hello)
+	if err := SendMessage(&Message{Type: MsgHello, Data: payload}); err != nil {
+		return nil, err
+	}
+
+	stream.SetReadDeadline(time.Now().Add(10 * time.Second))
+	msg, err := readFrame(reader)
+	stream.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("reading server hello: %w", err)
+	}
+
+	switch msg.Type {
+	case MsgHello:
+		var server Hello
+		if err := json.Unmarshal(msg.Data, &server); err != nil {
+			return nil, fmt.Errorf("decoding server hello: %w", err)
+		}
+		session = server.Session
+		return &server, nil
+	case MsgReject:
+		var reject Reject
+		if err := json.Unmarshal(msg.Data, &reject); err != nil {
+			return nil, fmt.Errorf("decoding reject: %w", err)
+		}
+		return nil, (*RejectError)(&reject)
+	default:
+		return nil, fmt.Errorf("unexpected message %d during handshake", msg.Type)
+	}
+}
+
+func handleReject(reject *RejectError) {
+	slog.Warn("Rejected by server", "code", reject.Code, "reason", reject.Reason)
+
+	switch reject.Code {
+	case RejectOutdated:
+		if !settings.AutoUpdate {
+			slog.Error("This version is no longer supported, install the latest release or enable autoupdate")
+			return
+		}
+		// stops the node gracefully, main restarts it on the new version
+		installed, err := installUpdate()
+		if err == nil && !installed {
+			slog.Error("This version is no longer supported and no newer release was found")
+		}
+	case RejectUnauthorized:
+		slog.Warn("Node identity was revoked, connect your account again to pair this node")
+		clearIdentity()
+		session = ""
+	}
+}
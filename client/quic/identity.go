@@ -0,0 +1,117 @@
+package quic
+
+import (
+	"client/config"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// Identity is issued by the server when the node is paired with an account
+type Identity struct {
+	NodeID string `json:"node_id"`
+	Token  string `json:"token"`
+}
+
+var (
+	identity      *Identity
+	identityMutex sync.Mutex
+	identityOnce  sync.Once
+
+	// identityChanged is called when the node is paired or loses its
+	// identity, set by the tray to switch its menu
+	identityChanged atomic.Pointer[func(paired bool)]
+)
+
+// OnIdentityChange registers f to be called with whether the node is paired
+// each time it gets or loses its identity
+func OnIdentityChange(f func(paired bool)) {
+	identityChanged.Store(&f)
+}
+
+func notifyIdentityChange(paired bool) {
+	if f := identityChanged.Load(); f != nil {
+		(*f)(paired)
+	}
+}
+
+func identityPath() (string, error) {
+	return config.Path("node.json")
+}
+
+// currentIdentity returns nil until the node is paired
+func currentIdentity() *Identity {
+	identityOnce.Do(func() {
+		identity = loadIdentity()
+	})
+
+	identityMutex.Lock()
+	defer identityMutex.Unlock()
+	return identity
+}
+
+func IsPaired() bool {
+	return currentIdentity() != nil
+}
+
+func loadIdentity() *Identity {
+	path, err := identityPath()
+	if err != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read node identity", "err", err)
+		}
+		return nil
+	}
+
+	var id Identity
+	if err := json.Unmarshal(data, &id); err != nil || id.NodeID == "" || id.Token == "" {
+		slog.Warn("Ignoring invalid node identity", "path", path)
+		return nil
+	}
+	return &id
+}
+
+// saveIdentity persists the identity, only readable by the current user
+func saveIdentity(id *Identity) error {
+	currentIdentity()
+
+	path, err := identityPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, _ := json.Marshal(id)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+
+	identityMutex.Lock()
+	identity = id
+	identityMutex.Unlock()
+	notifyIdentityChange(true)
+	return nil
+}
+
+func clearIdentity() {
+	currentIdentity()
+
+	if path, err := identityPath(); err == nil {
+		os.Remove(path)
+	}
+
+	identityMutex.Lock()
+	identity = nil
+	identityMutex.Unlock()
+	notifyIdentityChange(false)
+}
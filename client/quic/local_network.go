@@ -0,0 +1,44 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// errLocalAddress refuses targets on the network of the node runner
+var errLocalAddress = errors.New("target is on a local network")
+
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// isLocalAddress reports loopback, link-local, RFC 1918, CGNAT, IPv6 ULA,
+// multicast and unspecified addresses. Users must never reach the router,
+// NAS or localhost services of the runner through the node.
+func isLocalAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsMulticast() ||
+		addr.IsUnspecified() ||
+		cgnat.Contains(addr) ||
+		addr.Is4() && addr.As4()[0] == 0 // "this network"
+}
+
+// dialer checks the address it connects to once resolved, a hostname that
+// resolves, or later rebinds, to a local address is refused as well
+var dialer = &net.Dialer{
+	Timeout: dialTimeout,
+	ControlContext: func(_ context.Context, _, address string, _ syscall.RawConn) error {
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		if isLocalAddress(addrPort.Addr()) {
+			return errLocalAddress
+		}
+		return nil
+	},
+}
@@ -0,0 +1,47 @@
+package quic
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIsLocalAddress(t *testing.T) {
+	tests := []struct {
+		addr  string
+		local bool
+	}{
+		{"127.0.0.1", true},
+		{"127.255.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.31.255.255", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true}, // cloud metadata
+		{"100.64.0.1", true},
+		{"100.127.255.255", true},
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"224.0.0.251", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"ff02::fb", true},
+		{"::ffff:192.168.1.1", true},
+		{"::ffff:127.0.0.1", true},
+
+		{"1.1.1.1", false},
+		{"93.184.216.34", false},
+		{"172.32.0.1", false},
+		{"100.128.0.1", false},
+		{"192.169.0.1", false},
+		{"2606:4700:4700::1111", false},
+		{"::ffff:8.8.8.8", false},
+	}
+
+	for _, tt := range tests {
+		if got := isLocalAddress(netip.MustParseAddr(tt.addr)); got != tt.local {
+			t.Errorf("isLocalAddress(%s) = %v, want %v", tt.addr, got, tt.local)
+		}
+	}
+}
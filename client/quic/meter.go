@@ -0,0 +1,218 @@
+package quic
+
+import (
+	"client/config"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errCapReached = errors.New("data cap reached")
+
+// Rates set by the runner, nil when unlimited
+var uploadBucket, downloadBucket *tokenBucket
+
+// tokenBucket refills rate tokens per second up to a second worth of them,
+// a nil bucket is unlimited
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens = min(b.rate, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// allow takes n tokens if the bucket holds them
+func (b *tokenBucket) allow(n int) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// wait takes n tokens, going into debt if needed, and sleeps until the debt
+// is paid back
+func (b *tokenBucket) wait(n int) {
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	b.refill()
+	b.tokens -= float64(n)
+	debt := -b.tokens
+	b.mutex.Unlock()
+
+	if debt > 0 {
+		time.Sleep(time.Duration(debt / b.rate * float64(time.Second)))
+	}
+}
+
+// meteredWriter holds writes to the rate of bucket and counts them towards
+// the caps, writes fail once a cap is reached
+type meteredWriter struct {
+	io.Writer
+	bucket *tokenBucket
+}
+
+func (w meteredWriter) Write(p []byte) (int, error) {
+	if capReached() {
+		return 0, errCapReached
+	}
+	w.bucket.wait(len(p))
+
+	n, err := w.Writer.Write(p)
+	countUsage(n)
+	return n, err
+}
+
+// allowDatagram tells whether a datagram of n bytes fits in the caps and the
+// rate of bucket, datagrams are dropped rather than delayed
+func allowDatagram(bucket *tokenBucket, n int) bool {
+	if capReached() || !bucket.allow(n) {
+		return false
+	}
+	countUsage(n)
+	return true
+}
+
+// usage counts the bytes relayed in the current day and month, it is saved
+// to usage.json so caps survive restarts
+type usage struct {
+	Day        string `json:"day"`
+	DayBytes   int64  `json:"day_bytes"`
+	Month      string `json:"month"`
+	MonthBytes int64  `json:"month_bytes"`
+}
+
+var (
+	relayed      usage
+	relayedDirty bool
+	relayedMutex sync.Mutex
+	// relayedTotal counts the bytes relayed since the node started
+	relayedTotal atomic.Int64
+)
+
+// rollover resets the counters of a day or month that ended, callers hold
+// relayedMutex
+func (u *usage) rollover(now time.Time) {
+	if day := now.Format(time.DateOnly); u.Day != day {
+		u.Day, u.DayBytes = day, 0
+		relayedDirty = true
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.MonthBytes = month, 0
+		relayedDirty = true
+	}
+}
+
+func countUsage(n int) {
+	if n <= 0 {
+		return
+	}
+	relayedTotal.Add(int64(n))
+
+	relayedMutex.Lock()
+	relayed.rollover(time.Now())
+	relayed.DayBytes += int64(n)
+	relayed.MonthBytes += int64(n)
+	relayedDirty = true
+	reached := capReason(relayed.DayBytes, relayed.MonthBytes) != ""
+	relayedMutex.Unlock()
+
+	if reached {
+		checkAvailability()
+	}
+}
+
+func usageTotals() (day, month int64) {
+	relayedMutex.Lock()
+	defer relayedMutex.Unlock()
+
+	relayed.rollover(time.Now())
+	return relayed.DayBytes, relayed.MonthBytes
+}
+
+func capReached() bool {
+	if sharing.DailyCap == 0 && sharing.MonthlyCap == 0 {
+		return false
+	}
+	return capReason(usageTotals()) != ""
+}
+
+func usagePath() (string, error) {
+	return config.Path("usage.json")
+}
+
+func loadUsage() {
+	path, err := usagePath()
+	if err != nil {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read data usage", "err", err)
+		}
+		return
+	}
+
+	relayedMutex.Lock()
+	defer relayedMutex.Unlock()
+	if err := json.Unmarshal(data, &relayed); err != nil {
+		slog.Warn("Ignoring invalid data usage", "path", path)
+		relayed = usage{}
+	}
+}
+
+// saveUsage writes the counters if they changed since the last save
+func saveUsage() {
+	relayedMutex.Lock()
+	if !relayedDirty {
+		relayedMutex.Unlock()
+		return
+	}
+	data, _ := json.Marshal(relayed)
+	relayedDirty = false
+	relayedMutex.Unlock()
+
+	path, err := usagePath()
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		slog.Warn("Failed to save data usage", "err", err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		slog.Warn("Failed to save data usage", "err", err)
+	}
+}
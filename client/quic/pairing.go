@@ -0,0 +1,73 @@
+package quic
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// PairingCode is issued by the server to unpaired nodes, the runner pairs
+// the node by entering it on the dashboard
+type PairingCode struct {
+	Code      string `json:"code"`
+	ExpiresIn int    `json:"expires_in"` // seconds
+}
+
+// openPairingLink is called with the link of the next code received, set
+// when the runner connects an account from the tray
+var openPairingLink atomic.Pointer[func(link string)]
+
+// Pair asks the server for a pairing code and calls open with the link to
+// the dashboard page where the runner signs in and confirms it
+func Pair(open func(link string)) error {
+	openPairingLink.Store(&open)
+	return SendMessage(&Message{Type: MsgPairCode})
+}
+
+// requestPairingCode asks the server for a code if a headless node still has
+// to be paired, nodes with a tray ask for one through Pair
+func requestPairingCode() {
+	if !settings.Headless || IsPaired() {
+		return
+	}
+	if err := SendMessage(&Message{Type: MsgPairCode}); err != nil {
+		slog.Warn("Failed to request a pairing code", "err", err)
+	}
+}
+
+// showPairingCode prints the code for the runner, whatever the log level,
+// opens it if it was asked for by Pair and asks for a new one once it
+// expires on the same connection
+func showPairingCode(payload []byte) {
+	var code PairingCode
+	if err := json.Unmarshal(payload, &code); err != nil || code.Code == "" {
+		slog.Warn("Received invalid pairing code")
+		return
+	}
+
+	expiresIn := time.Duration(code.ExpiresIn) * time.Second
+	link := settings.Dashboard + "/desktop-auth/code?code=" + url.QueryEscape(code.Code)
+	fmt.Printf("\nTo pair this node with your account, open\n\n    %s\n\n"+
+		"or enter the code %s on the dashboard. The code expires in %s.\n\n", link, code.Code, expiresIn)
+
+	if open := openPairingLink.Swap(nil); open != nil {
+		(*open)(link)
+	}
+
+	quicMutex.Lock()
+	conn := quicConn
+	quicMutex.Unlock()
+
+	time.AfterFunc(expiresIn, func() {
+		quicMutex.Lock()
+		current := quicConn
+		quicMutex.Unlock()
+
+		if current == conn {
+			requestPairingCode()
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package quic
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Protocol is the ALPN identifier of the binary frame protocol
+const Protocol = "turbo-proxy/2"
+
+// MessageType values must stay in sync with the server
+type MessageType uint8
+
+const (
+	MsgConnect MessageType = iota + 1
+	MsgData
+	MsgClose
+	MsgPing
+	MsgPong
+	MsgAddress
+	MsgUIDRegister
+	MsgStacktrace
+	MsgDummy
+	MsgHello
+	MsgReject
+	MsgIdentity
+	MsgDatagram
+	MsgConnected
+	MsgConnectError
+	MsgAvailability
+	MsgPairCode
+)
+
+const (
+	frameHeaderSize = 9
+	maxFramePayload = 1 << 20
+)
+
+/*
+Frame layout, integers are big endian:
+
+	+------+---------------+-------------+---------+
+	| type | connection ID | payload len | payload |
+	|  1B  |      4B       |     4B      |   ...   |
+	+------+---------------+-------------+---------+
+
+A connect payload starts with the 2-byte target address length,
+followed by the address and the first bytes sent by the user.
+The node answers on the same stream with a connected frame once it
+reached the target, then relays, or with a connect_error frame holding
+the reason of the failure.
+Datagram frames carry the address the same way, the ID is then a UDP
+association and the frame is sent as a QUIC datagram whenever it fits.
+Availability frames hold the JSON Availability of the node, sent after the
+handshake and whenever the node starts or stops sharing. Nodes that are not
+paired send an empty pair_code frame, the server answers with a PairingCode
+for the runner to enter on the dashboard.
+*/
+
+// hasAddr reports whether the payload starts with an address
+func (t MessageType) hasAddr() bool {
+	return t == MsgConnect || t == MsgDatagram
+}
+
+func readFrame(r io.Reader) (Message, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{
+		Type: MessageType(header[0]),
+		ID:   binary.BigEndian.Uint32(header[1:5]),
+	}
+
+	size := binary.BigEndian.Uint32(header[5:9])
+	if size > maxFramePayload {
+		return Message{}, fmt.Errorf("frame payload of %d bytes exceeds limit", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Message{}, err
+	}
+
+	if !msg.Type.hasAddr() {
+		msg.Data = payload
+		return msg, nil
+	}
+
+	if len(payload) < 2 {
+		return Message{}, fmt.Errorf("frame address length missing")
+	}
+	addrLen := int(binary.BigEndian.Uint16(payload))
+	if len(payload) < 2+addrLen {
+		return Message{}, fmt.Errorf("frame address truncated")
+	}
+	msg.Addr = string(payload[2 : 2+addrLen])
+	msg.Data = payload[2+addrLen:]
+
+	return msg, nil
+}
+
+func appendFrame(buf []byte, msg *Message) ([]byte, error) {
+	size := len(msg.Data)
+	if msg.Type.hasAddr() {
+		size += 2 + len(msg.Addr)
+	}
+	if size > maxFramePayload {
+		return buf, fmt.Errorf("frame payload of %d bytes exceeds limit", size)
+	}
+
+	buf = append(buf, byte(msg.Type))
+	buf = binary.BigEndian.AppendUint32(buf, msg.ID)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(size))
+	if msg.Type.hasAddr() {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(msg.Addr)))
+		buf = append(buf, msg.Addr...)
+	}
+	return append(buf, msg.Data...), nil
+}
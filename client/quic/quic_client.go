@@ -1,13 +1,15 @@
 package quic
 
 import (
+	"bufio"
+	"client/config"
 	"context"
 	"crypto/tls"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -15,63 +17,87 @@ import (
 )
 
 type Message struct {
-	Type string `json:"type"`
-	ID   string `json:"id"`
-	Addr string `json:"addr,omitempty"`
-	Data string `json:"data,omitempty"`
+	Type MessageType
+	ID   uint32
+	Addr string
+	Data []byte
 }
 
-type Connection struct {
-	conn     net.Conn
-	dataChan chan []byte
-}
+// maxConnections bounds the streams, hence user connections, the server can open at once
+const maxConnections = 1024
 
 var (
-	quicConn    *quic.Conn
-	quicStream  *quic.Stream
-	quicMutex   sync.Mutex
-	clientConns = make(map[string]*Connection)
-	clientMutex sync.Mutex
+	quicConn   *quic.Conn
+	quicStream *quic.Stream
+	quicMutex  sync.Mutex
+	frameBuf   []byte
 )
 
 /* On disconnect:
-Waits for 5 seconds 2 times
+Tries every server, waits for 5 seconds 2 times
 Then waits for 5 minutes forever
 */
 
-func ConnectQuicServer() {
+// settings of the node and how it installs updates, set by ConnectQuicServer
+var (
+	settings      *config.Config
+	installUpdate func() (bool, error)
+)
+
+// drainTimeout is how long relays may take to finish on shutdown, within
+// the grace period of docker stop
+const drainTimeout = 8 * time.Second
+
+// ConnectQuicServer runs the node until ctx is done, then stops taking
+// connections and closes the connection to the server once the relays in
+// progress finished or drainTimeout passed. install installs the latest
+// release when the server refuses this version, and stops the node for it
+// to restart on the new one.
+func ConnectQuicServer(ctx context.Context, c *config.Config, install func() (bool, error)) {
+	settings = c
+	installUpdate = install
+	InitSharing(c.Sharing)
+
 	connectionAttempts := 0
 	retryDelay := time.Second * 4
 
-	tlsConf := &tls.Config{
-		InsecureSkipVerify: true, // Note: In production, use proper certificate validation
-		NextProtos:         []string{"turbo-proxy"},
+	tlsConf := tlsConfig(c)
+
+	// mirrors the server: a target that stops reading only holds the
+	// credit of its own stream
+	quicConf := &quic.Config{
+		MaxIncomingStreams:             maxConnections,
+		InitialStreamReceiveWindow:     256 << 10,
+		MaxStreamReceiveWindow:         1 << 20,
+		InitialConnectionReceiveWindow: 64 << 20,
+		MaxConnectionReceiveWindow:     64 << 20,
+		// unpaired nodes may wait a long time for the user to connect an account
+		KeepAlivePeriod: 15 * time.Second,
+		EnableDatagrams: true,
 	}
 
-	for {
-		ctx := context.Background()
-		conn, err := quic.DialAddr(ctx, "192.168.1.144:8443", tlsConf, nil)
+	for ctx.Err() == nil {
+		conn, err := dialServers(ctx, c.Servers, tlsConf, quicConf)
 		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
 			if connectionAttempts == 2 {
 				retryDelay = time.Minute * 5
 			}
 
-			log.Println("Failed to connect to QUIC server. Retrying...")
-			log.Println(err)
-			time.Sleep(retryDelay)
+			slog.Warn("Failed to connect to QUIC server, retrying", "in", retryDelay)
+			sleep(ctx, retryDelay)
 			connectionAttempts++
 			continue
 		}
-		log.Println("Connected to QUIC server")
-
-		// let the server accept our bidirectional stream and register us
-		time.Sleep(100 * time.Millisecond)
+		slog.Info("Connected to QUIC server", "addr", conn.RemoteAddr())
 
 		stream, err := conn.OpenStreamSync(ctx)
 		if err != nil {
-			log.Println("Failed to open QUIC stream:", err)
+			slog.Error("Failed to open QUIC stream", "err", err)
 			conn.CloseWithError(1, "failed to open stream")
-			time.Sleep(retryDelay)
+			sleep(ctx, retryDelay)
 			connectionAttempts++
 			continue
 		}
@@ -82,65 +108,134 @@ func ConnectQuicServer() {
 		quicMutex.Unlock()
 		connectionAttempts = 0
 
-		SendMessage(&Message{Type: "dummy"})
+		reader := bufio.NewReader(stream)
+		server, err := handshake(stream, reader)
+		if err != nil {
+			var reject *RejectError
+			if errors.As(err, &reject) {
+				handleReject(reject)
+			} else {
+				slog.Error("Handshake failed", "err", err)
+			}
+			conn.CloseWithError(0, "handshake failed")
+			sleep(ctx, retryDelay)
+			connectionAttempts++
+			continue
+		}
+		setRegistered(true)
+		if id := currentIdentity(); id != nil {
+			slog.Info("Registered with server", "node", id.NodeID, "protocol", server.Protocol)
+		} else {
+			slog.Info("Connected to server, waiting for pairing", "protocol", server.Protocol)
+		}
+
+		if err := announceAvailability(); err != nil {
+			slog.Warn("Failed to announce availability", "err", err)
+		}
+		requestPairingCode()
+
+		stopDrain := context.AfterFunc(ctx, func() { drain(conn) })
+		go acceptStreams(conn)
+		go receiveDatagrams(conn)
+		quicReader(reader)
+		stopDrain()
+		setRegistered(false)
+		conn.CloseWithError(0, "control stream closed")
+		closeAllAssociations()
+
+		if ctx.Err() != nil {
+			break
+		}
+		slog.Info("QUIC connection closed, reconnecting...")
+
+		sleep(ctx, time.Second*5)
+	}
 
-		quicReader(stream)
+	stopped.Store(true)
+	saveUsage()
+	slog.Info("Node stopped")
+}
 
-		log.Println("QUIC connection closed, reconnecting...")
+// drain announces the node is shutting down and closes conn once the
+// relays in progress are done
+func drain(conn *quic.Conn) {
+	slog.Info("Shutting down, waiting for connections to finish", "connections", activeConns.Load())
+	shuttingDown.Store(true)
+	updateAvailability()
 
-		time.Sleep(time.Second * 5)
+	deadline := time.Now().Add(drainTimeout)
+	for activeConns.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
 	}
+	conn.CloseWithError(0, "node shutting down")
 }
 
-func quicReader(stream *quic.Stream) {
-	decoder := json.NewDecoder(stream)
+// sleep waits for d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// dialServers connects to the first of servers that answers
+func dialServers(ctx context.Context, servers []string, tlsConf *tls.Config, quicConf *quic.Config) (*quic.Conn, error) {
+	var err error
+	for _, server := range servers {
+		var conn *quic.Conn
+		conn, err = quic.DialAddr(ctx, server, tlsConf, quicConf)
+		if err == nil {
+			return conn, nil
+		}
+		slog.Warn("Failed to connect to QUIC server", "addr", server, "err", err)
+
+		var unverified *tls.CertificateVerificationError
+		if errors.As(err, &unverified) {
+			slog.Error("The certificate of the server is not trusted, set tls_pin to the pin the server logs at start instead of tls_verify")
+		}
+	}
+	return nil, err
+}
 
+func quicReader(reader *bufio.Reader) {
 	for {
-		var msg Message
-		err := decoder.Decode(&msg)
+		msg, err := readFrame(reader)
 		if err != nil {
-			log.Println("QUIC read error:", err)
-			clientMutex.Lock()
-			for id, cc := range clientConns {
-				cc.conn.Close()
-				close(cc.dataChan)
-				delete(clientConns, id)
-			}
-			clientMutex.Unlock()
-
+			slog.Warn("QUIC read error", "err", err)
 			return
 		}
 
-		log.Printf("received %+v", msg.Type)
+		slog.Debug("Received message", "type", msg.Type)
 
 		switch msg.Type {
-		case "connect":
-			log.Println("to-to ", msg.Addr)
-			go handleConnect(msg)
-		case "data":
-			clientMutex.Lock()
-			if cc, ok := clientConns[msg.ID]; ok {
-				if data, err := base64.StdEncoding.DecodeString(msg.Data); err == nil {
-					cc.dataChan <- data
-				}
-			}
-			clientMutex.Unlock()
-		case "close":
-			clientMutex.Lock()
-			if cc, ok := clientConns[msg.ID]; ok {
-				cc.conn.Close()
-				close(cc.dataChan)
-				delete(clientConns, msg.ID)
-			}
-			clientMutex.Unlock()
-		case "ping":
+		case MsgPing:
 			err := SendMessage(&Message{
-				Type: "pong",
+				Type: MsgPong,
 				ID:   msg.ID,
 			})
 			if err != nil {
 				log.Fatal("error sending pong:", err)
 			}
+		case MsgDatagram:
+			handleDatagram(msg)
+		case MsgClose:
+			closeAssociation(msg.ID)
+		case MsgPairCode:
+			showPairingCode(msg.Data)
+		case MsgIdentity:
+			var id Identity
+			if err := json.Unmarshal(msg.Data, &id); err != nil || id.NodeID == "" {
+				slog.Warn("Received invalid node identity")
+				continue
+			}
+			if err := saveIdentity(&id); err != nil {
+				slog.Error("Failed to save node identity", "err", err)
+				continue
+			}
+			slog.Info("Paired with server", "node", id.NodeID)
 		}
 	}
 }
@@ -150,34 +245,22 @@ func SendMessage(msg *Message) error {
 	defer quicMutex.Unlock()
 
 	if quicStream == nil {
-		log.Println("Cannot send message: no active QUIC stream")
+		slog.Debug("Cannot send message: no active QUIC stream")
 		return fmt.Errorf("no active QUIC stream")
 	}
 
-	data, err := json.Marshal(msg)
+	data, err := appendFrame(frameBuf[:0], msg)
 	if err != nil {
-		log.Printf("Failed to marshal message of type %s: %v", msg.Type, err)
+		slog.Error("Failed to encode message", "type", msg.Type, "err", err)
 		return err
 	}
-	data = append(data, '\n')
+	frameBuf = data
 
 	_, err = quicStream.Write(data)
 	if err != nil {
-		log.Printf("Error writing to QUIC stream: %v", err)
+		slog.Warn("Error writing to QUIC stream", "err", err)
 		return err
 	}
 
 	return nil
 }
-
-func sendCloseMessage(id string) {
-	msg := Message{Type: "close", ID: id}
-	SendMessage(&msg)
-	clientMutex.Lock()
-	if cc, ok := clientConns[id]; ok {
-		cc.conn.Close()
-		close(cc.dataChan)
-		delete(clientConns, id)
-	}
-	clientMutex.Unlock()
-}
@@ -1,26 +1,66 @@
 package quic
 
-import "encoding/base64"
-
-func relayFromConnToQuic(cc *Connection, id string) {
-	buf := make([]byte, 4096)
-	for {
-		n, err := cc.conn.Read(buf)
-		if err != nil {
-			sendCloseMessage(id)
-			return
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// targets that stop reading are dropped after stallTimeout, releasing the
+// flow control credit their unread data holds on the server connection
+const stallTimeout = 30 * time.Second
+
+// relay copies data between the target and the server at the rates set by
+// the runner. A direction that ends cleanly is half-closed on the other
+// side, the connection is closed once both directions are done or as soon
+// as one of them fails, which includes a data cap being reached.
+func relay(conn net.Conn, stream *quic.Stream) {
+	done := make(chan error, 2)
+
+	go func() {
+		_, err := io.Copy(meteredWriter{stream, downloadBucket}, conn)
+		if err == nil {
+			err = stream.Close()
+		}
+		done <- err
+	}()
+
+	go func() {
+		_, err := io.Copy(meteredWriter{stallWriter{conn}, uploadBucket}, stream)
+		if err == nil {
+			err = closeWrite(conn)
+		}
+		done <- err
+	}()
+
+	for range 2 {
+		if err := <-done; err != nil {
+			stream.CancelRead(0)
+			stream.CancelWrite(0)
+			break
 		}
-		data := base64.StdEncoding.EncodeToString(buf[:n])
-		msg := Message{Type: "data", ID: id, Data: data}
-		SendMessage(&msg)
 	}
+	conn.Close()
 }
 
-func relayFromChanToConn(cc *Connection, id string) {
-	for data := range cc.dataChan {
-		if _, err := cc.conn.Write(data); err != nil {
-			sendCloseMessage(id)
-			return
-		}
+var errNoHalfClose = errors.New("connection cannot be half-closed")
+
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
 	}
+	return errNoHalfClose
+}
+
+// stallWriter fails writes that the peer does not drain within stallTimeout
+type stallWriter struct {
+	net.Conn
+}
+
+func (w stallWriter) Write(p []byte) (int, error) {
+	w.SetWriteDeadline(time.Now().Add(stallTimeout))
+	return w.Conn.Write(p)
 }
@@ -0,0 +1,181 @@
+package quic
+
+import (
+	"client/config"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reasons a node announces when it stops sharing
+const (
+	UnavailableDailyCap   = "daily_cap"
+	UnavailableMonthlyCap = "monthly_cap"
+	UnavailableSchedule   = "schedule"
+	UnavailableShutdown   = "shutdown"
+	// UnavailableManual is announced while the runner paused sharing
+	UnavailableManual = "manual"
+)
+
+// Availability is announced to the server, which only routes users to
+// available nodes
+type Availability struct {
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// availabilityInterval is how often caps and the schedule are checked, a cap
+// being reached is announced right away
+const availabilityInterval = 30 * time.Second
+
+var (
+	sharing config.Sharing
+
+	announced         Availability
+	announceMutex     sync.Mutex
+	availabilityCheck = make(chan struct{}, 1)
+	shuttingDown      atomic.Bool
+	paused            atomic.Bool
+)
+
+// InitSharing applies the sharing settings of the runner, loads the data
+// usage of the node and starts announcing availability changes
+func InitSharing(settings config.Sharing) {
+	sharing = settings
+	uploadBucket = newTokenBucket(float64(sharing.UploadRate))
+	downloadBucket = newTokenBucket(float64(sharing.DownloadRate))
+	loadUsage()
+
+	announced = currentAvailability()
+	if !announced.Available {
+		slog.Info("Not sharing", "reason", announced.Reason)
+	}
+	go watchAvailability()
+}
+
+func inSchedule(now time.Time) bool {
+	if len(sharing.Windows) == 0 {
+		return true
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	for _, w := range sharing.Windows {
+		if w.Contains(minute) {
+			return true
+		}
+	}
+	return false
+}
+
+// capReason returns the cap reached by the totals of the day and month, or
+// "" when there is none
+func capReason(day, month int64) string {
+	switch {
+	case sharing.DailyCap > 0 && day >= int64(sharing.DailyCap):
+		return UnavailableDailyCap
+	case sharing.MonthlyCap > 0 && month >= int64(sharing.MonthlyCap):
+		return UnavailableMonthlyCap
+	}
+	return ""
+}
+
+func currentAvailability() Availability {
+	if shuttingDown.Load() {
+		return Availability{Reason: UnavailableShutdown}
+	}
+	if paused.Load() {
+		return Availability{Reason: UnavailableManual}
+	}
+	if reason := capReason(usageTotals()); reason != "" {
+		return Availability{Reason: reason}
+	}
+	if !inSchedule(time.Now()) {
+		return Availability{Reason: UnavailableSchedule}
+	}
+	return Availability{Available: true}
+}
+
+// Pause stops taking new connections until Resume, connections in progress
+// go on. Pausing does not outlive the process.
+func Pause() {
+	paused.Store(true)
+	updateAvailability()
+}
+
+// Resume undoes Pause, caps and the schedule still apply
+func Resume() {
+	paused.Store(false)
+	updateAvailability()
+}
+
+// IsPaused tells whether the runner paused sharing
+func IsPaused() bool {
+	return paused.Load()
+}
+
+// currentSharing returns the availability last announced
+func currentSharing() Availability {
+	announceMutex.Lock()
+	defer announceMutex.Unlock()
+	return announced
+}
+
+// isSharing tells whether the node takes new connections
+func isSharing() bool {
+	return currentSharing().Available
+}
+
+// checkAvailability wakes watchAvailability up without waiting for the
+// next tick
+func checkAvailability() {
+	select {
+	case availabilityCheck <- struct{}{}:
+	default:
+	}
+}
+
+func watchAvailability() {
+	ticker := time.NewTicker(availabilityInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-availabilityCheck:
+		}
+
+		saveUsage()
+		updateAvailability()
+	}
+}
+
+// updateAvailability announces the availability of the node if it changed
+func updateAvailability() {
+	current := currentAvailability()
+	announceMutex.Lock()
+	changed := current != announced
+	announced = current
+	announceMutex.Unlock()
+
+	if !changed {
+		return
+	}
+	if current.Available {
+		slog.Info("Sharing resumed")
+	} else {
+		slog.Info("Sharing paused", "reason", current.Reason)
+	}
+	announceAvailability()
+}
+
+// announceAvailability sends the current availability to the server, it is
+// also sent after each handshake
+func announceAvailability() error {
+	announceMutex.Lock()
+	payload, _ := json.Marshal(announced)
+	announceMutex.Unlock()
+
+	return SendMessage(&Message{Type: MsgAvailability, Data: payload})
+}
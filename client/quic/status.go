@@ -0,0 +1,98 @@
+package quic
+
+import (
+	"client/platform/update"
+	"sync/atomic"
+	"time"
+)
+
+// States of the node reported by CurrentStatus
+const (
+	StateConnecting = "connecting"
+	// StatePairing is connected to a server, waiting for the runner to pair
+	// the node with an account
+	StatePairing   = "pairing"
+	StateConnected = "connected"
+	StateStopped   = "stopped"
+)
+
+// Status describes what the node is doing, for the runner
+type Status struct {
+	State   string `json:"state"`
+	Server  string `json:"server,omitempty"`
+	NodeID  string `json:"node_id,omitempty"`
+	Version string `json:"version"`
+	// Uptime is the number of seconds since the node started and Connected
+	// since it registered with the current server
+	Uptime    int64 `json:"uptime_seconds"`
+	Connected int64 `json:"connected_seconds,omitempty"`
+	// Latency is the smoothed round-trip time to the server
+	Latency     float64      `json:"latency_ms,omitempty"`
+	Connections int32        `json:"connections"`
+	Sharing     Availability `json:"sharing"`
+	Shared      Shared       `json:"shared"`
+}
+
+// Shared counts the bytes relayed in both directions since the node started
+// and in the current day and month
+type Shared struct {
+	Session int64 `json:"session"`
+	Today   int64 `json:"today"`
+	Month   int64 `json:"month"`
+}
+
+var (
+	startedAt = time.Now()
+
+	// registeredAt is zero while the node is not registered with a server,
+	// guarded by quicMutex like quicConn
+	registeredAt time.Time
+	stopped      atomic.Bool
+)
+
+// setRegistered records whether the node is registered on quicConn
+func setRegistered(registered bool) {
+	quicMutex.Lock()
+	defer quicMutex.Unlock()
+
+	if registered {
+		registeredAt = time.Now()
+	} else {
+		registeredAt = time.Time{}
+	}
+}
+
+// CurrentStatus returns a snapshot of the state of the node
+func CurrentStatus() Status {
+	status := Status{
+		State:       StateConnecting,
+		Version:     update.VERSION,
+		Uptime:      int64(time.Since(startedAt).Seconds()),
+		Connections: activeConns.Load(),
+		Sharing:     currentSharing(),
+	}
+
+	quicMutex.Lock()
+	if !registeredAt.IsZero() && quicConn != nil {
+		status.State = StatePairing
+		status.Server = quicConn.RemoteAddr().String()
+		status.Connected = int64(time.Since(registeredAt).Seconds())
+		rtt := quicConn.ConnectionStats().SmoothedRTT
+		status.Latency = float64(rtt.Microseconds()) / 1000
+	}
+	quicMutex.Unlock()
+
+	if id := currentIdentity(); id != nil {
+		status.NodeID = id.NodeID
+		if status.State == StatePairing {
+			status.State = StateConnected
+		}
+	}
+	if stopped.Load() {
+		status.State = StateStopped
+	}
+
+	status.Shared.Session = relayedTotal.Load()
+	status.Shared.Today, status.Shared.Month = usageTotals()
+	return status
+}
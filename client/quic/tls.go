@@ -0,0 +1,49 @@
+package quic
+
+import (
+	"bytes"
+	"client/config"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+)
+
+var errPinMismatch = errors.New("server public key does not match tls_pin")
+
+// tlsConfig verifies the servers against the pin of the configuration, or the
+// system roots with tls_verify. Neither leaves them unverified, as releases
+// before tls_pin did, so that nodes updating keep their connection.
+func tlsConfig(c *config.Config) *tls.Config {
+	conf := &tls.Config{NextProtos: []string{Protocol}}
+
+	switch {
+	case c.Pin() != nil:
+		// the pin replaces the chain verification, self-signed servers are fine
+		pin := c.Pin()
+		conf.InsecureSkipVerify = true
+		conf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errPinMismatch
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if !bytes.Equal(hash[:], pin) {
+				return errPinMismatch
+			}
+			return nil
+		}
+	case c.TLSInsecure:
+		slog.Warn("Server certificates are not verified, set tls_pin to verify them")
+		conf.InsecureSkipVerify = true
+	case !c.TLSVerify:
+		slog.Warn("Server certificates are not verified until tls_pin or tls_verify is set, " +
+			"a later release will require one of them")
+		conf.InsecureSkipVerify = true
+	}
+	return conf
+}
@@ -0,0 +1,263 @@
+package quic
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// udpIdleTimeout releases the socket of an association nobody uses anymore
+	udpIdleTimeout = 2 * time.Minute
+	// maxAssociations bounds the UDP sockets the server can make the node open
+	maxAssociations = 256
+	// maxHosts bounds the hostnames an association resolves, each one once
+	maxHosts = 32
+	// maxPendingDatagrams are kept per hostname while it resolves
+	maxPendingDatagrams = 8
+)
+
+// udpAssociation relays the datagrams of a SOCKS UDP association, replies
+// from any target are sent back to the server.
+type udpAssociation struct {
+	id         uint32
+	conn       *net.UDPConn
+	lastActive atomic.Int64
+
+	hostsMutex sync.Mutex
+	hosts      map[string]*resolvedHost
+}
+
+// resolvedHost is a hostname target of an association, looked up on its
+// first datagram and kept for the lifetime of the association
+type resolvedHost struct {
+	addr     netip.AddrPort
+	err      error
+	resolved bool
+	pending  [][]byte // datagrams received while resolving
+}
+
+var (
+	associations      = make(map[uint32]*udpAssociation)
+	associationsMutex sync.Mutex
+)
+
+func receiveDatagrams(conn *quic.Conn) {
+	for {
+		b, err := conn.ReceiveDatagram(conn.Context())
+		if err != nil {
+			return
+		}
+
+		msg, err := readFrame(bytes.NewReader(b))
+		if err != nil || msg.Type != MsgDatagram {
+			continue
+		}
+		handleDatagram(msg)
+	}
+}
+
+func handleDatagram(msg Message) {
+	if !isSharing() {
+		return
+	}
+
+	a := association(msg.ID)
+	if a == nil {
+		return
+	}
+
+	target, err := netip.ParseAddrPort(msg.Addr)
+	if err == nil {
+		a.send(target, msg.Data)
+		return
+	}
+	a.sendToHost(msg.Addr, msg.Data)
+}
+
+// sendToHost sends payload to a host:port target once it resolved, resolving
+// must not hold up the datagrams of other targets
+func (a *udpAssociation) sendToHost(hostport string, payload []byte) {
+	a.hostsMutex.Lock()
+	host, ok := a.hosts[hostport]
+	if !ok {
+		if len(a.hosts) >= maxHosts {
+			a.hostsMutex.Unlock()
+			slog.Debug("Dropping datagram, too many hostnames on the association", "addr", hostport)
+			return
+		}
+		host = &resolvedHost{}
+		a.hosts[hostport] = host
+		go a.resolve(hostport, host)
+	}
+	if !host.resolved {
+		if len(host.pending) < maxPendingDatagrams {
+			host.pending = append(host.pending, payload)
+		}
+		a.hostsMutex.Unlock()
+		return
+	}
+	target, err := host.addr, host.err
+	a.hostsMutex.Unlock()
+
+	if err == nil {
+		a.send(target, payload)
+	}
+}
+
+func (a *udpAssociation) resolve(hostport string, host *resolvedHost) {
+	addr, err := net.ResolveUDPAddr("udp", hostport)
+	if err != nil {
+		slog.Debug("Failed to resolve datagram target", "addr", hostport, "err", err)
+	}
+
+	a.hostsMutex.Lock()
+	if err == nil {
+		host.addr = addr.AddrPort()
+	}
+	host.err = err
+	host.resolved = true
+	pending := host.pending
+	host.pending = nil
+	a.hostsMutex.Unlock()
+
+	if err == nil {
+		for _, payload := range pending {
+			a.send(host.addr, payload)
+		}
+	}
+}
+
+func (a *udpAssociation) send(target netip.AddrPort, payload []byte) {
+	if isLocalAddress(target.Addr()) {
+		slog.Debug("Dropping datagram to a local address", "addr", target)
+		return
+	}
+	if !allowDatagram(uploadBucket, len(payload)) {
+		return
+	}
+	if _, err := a.conn.WriteToUDPAddrPort(payload, target); err == nil {
+		a.touch()
+	}
+}
+
+// association returns the association id, opening it on its first datagram
+func association(id uint32) *udpAssociation {
+	associationsMutex.Lock()
+	defer associationsMutex.Unlock()
+
+	if a, ok := associations[id]; ok {
+		return a
+	}
+	if len(associations) >= maxAssociations {
+		return nil
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		slog.Warn("Failed to open UDP socket", "err", err)
+		return nil
+	}
+
+	a := &udpAssociation{id: id, conn: conn, hosts: make(map[string]*resolvedHost)}
+	a.touch()
+	associations[id] = a
+	go a.relayFromTargets()
+	return a
+}
+
+func (a *udpAssociation) touch() {
+	a.lastActive.Store(time.Now().UnixNano())
+}
+
+func (a *udpAssociation) idleDeadline() time.Time {
+	return time.Unix(0, a.lastActive.Load()).Add(udpIdleTimeout)
+}
+
+func (a *udpAssociation) relayFromTargets() {
+	defer closeAssociation(a.id)
+
+	buf := make([]byte, 64<<10)
+	for {
+		a.conn.SetReadDeadline(a.idleDeadline())
+		n, from, err := a.conn.ReadFromUDPAddrPort(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) && time.Now().Before(a.idleDeadline()) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if isLocalAddress(from.Addr()) {
+			// only answers from the internet reach the user
+			slog.Debug("Dropping datagram from a local address", "addr", from)
+			continue
+		}
+		if !allowDatagram(downloadBucket, n) {
+			continue
+		}
+		a.touch()
+
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+		err = sendDatagram(&Message{Type: MsgDatagram, ID: a.id, Addr: from.String(), Data: buf[:n]})
+		if err != nil {
+			return
+		}
+	}
+}
+
+func closeAssociation(id uint32) {
+	associationsMutex.Lock()
+	a, ok := associations[id]
+	delete(associations, id)
+	associationsMutex.Unlock()
+
+	if ok {
+		a.conn.Close()
+	}
+}
+
+func closeAllAssociations() {
+	associationsMutex.Lock()
+	ids := make([]uint32, 0, len(associations))
+	for id := range associations {
+		ids = append(ids, id)
+	}
+	associationsMutex.Unlock()
+
+	for _, id := range ids {
+		closeAssociation(id)
+	}
+}
+
+// sendDatagram uses a QUIC datagram when it fits and the control stream
+// otherwise, datagrams are never split.
+func sendDatagram(msg *Message) error {
+	quicMutex.Lock()
+	conn := quicConn
+	quicMutex.Unlock()
+
+	if conn == nil {
+		return errors.New("no active QUIC connection")
+	}
+
+	if conn.ConnectionState().SupportsDatagrams {
+		frame, err := appendFrame(nil, msg)
+		if err != nil {
+			return err
+		}
+
+		var tooLarge *quic.DatagramTooLargeError
+		if err := conn.SendDatagram(frame); !errors.As(err, &tooLarge) {
+			return err
+		}
+	}
+	return SendMessage(msg)
+}
@@ -1,8 +1,10 @@
+//go:build !headless
+
 package ui
 
 import (
 	"client/quic"
-	"log"
+	"log/slog"
 	"os/exec"
 	"runtime"
 
@@ -15,27 +17,49 @@ func SetupTray(websiteUrl string, icon []byte) {
 
 	connect := systray.AddMenuItem("Connect", "Connect with your account")
 	dashboard := systray.AddMenuItem("Dashboard", "Open dashboard")
+	pause := systray.AddMenuItemCheckbox("Pause sharing", "Stop taking new connections", quic.IsPaused())
 	systray.AddSeparator()
 	quitItem := systray.AddMenuItem("Quit", "Quit the whole app")
 
-	dashboard.Hide()
+	// the menu only switches once the server issued the identity, a code
+	// can expire or be rejected
+	showPaired := func(paired bool) {
+		if paired {
+			connect.Hide()
+			dashboard.Show()
+		} else {
+			dashboard.Hide()
+			connect.Show()
+		}
+	}
+	quic.OnIdentityChange(showPaired)
+	showPaired(quic.IsPaired())
 
 	go func() {
 		for {
 			select {
 			case <-connect.ClickedCh:
-				port := quic.UIDCollector()
-				err := open(websiteUrl + "/desktop-auth/check?port=" + port)
+				err := quic.Pair(func(link string) {
+					if err := open(link); err != nil {
+						slog.Warn("Failed to open browser", "err", err)
+					}
+				})
 				if err != nil {
-					log.Println("Failed to open browser:", err)
+					slog.Warn("Failed to request a pairing code", "err", err)
 				}
-
-				connect.Hide()
-				dashboard.Show()
 			case <-dashboard.ClickedCh:
 				err := open(websiteUrl + "/dashboard")
 				if err != nil {
-					log.Println("Failed to open browser:", err)
+					slog.Warn("Failed to open browser", "err", err)
+				}
+			case <-pause.ClickedCh:
+				// the control API may have changed it since the last click
+				if quic.IsPaused() {
+					quic.Resume()
+					pause.Uncheck()
+				} else {
+					quic.Pause()
+					pause.Check()
 				}
 			case <-quitItem.ClickedCh:
 				systray.Quit()
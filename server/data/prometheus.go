@@ -1,6 +1,10 @@
 package data
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 // Prometheus metrics for your specific requirements
 var (
@@ -29,8 +33,35 @@ var (
 		},
 		[]string{"protocol", "direction", "client_country"}, // in/out
 	)
+
+	userThrottled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "proxy_user_throttled_total",
+			Help: "Connections, requests and datagrams refused or delayed by user limits",
+		},
+		[]string{"limit"}, // connections, requests, bandwidth
+	)
+
+	userThrottleDelay = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "proxy_user_throttle_delay_seconds_total",
+			Help: "Time relayed traffic was held back by user bandwidth limits",
+		},
+	)
 )
 
+func init() {
+	prometheus.MustRegister(userThrottled, userThrottleDelay)
+}
+
 func LogBytesTransferred(protocol, direction, clientCountry string, bytes int) {
 	bytesTransferred.WithLabelValues(protocol, direction, clientCountry).Add(float64(bytes))
 }
+
+func LogThrottled(limit string) {
+	userThrottled.WithLabelValues(limit).Inc()
+}
+
+func LogThrottleDelay(delay time.Duration) {
+	userThrottleDelay.Add(delay.Seconds())
+}
@@ -0,0 +1,266 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// API keys look like tp_<id>_<secret>. The account lives in the redis hash
+// apikey:<id>, which only holds an HMAC-SHA256 of the secret:
+//
+//	secret      hex HMAC of the secret, keyed with API_KEY_SECRET
+//	credits     remaining balance in bytes relayed
+//	plain_http  may forward unencrypted HTTP requests
+//
+// Limits of the plan of the account, absent or 0 when unlimited:
+//
+//	max_conns            concurrent connections
+//	bandwidth            bytes per second
+//	requests_per_minute  new connections and forwarded requests
+//
+// Passwords handed out before API keys have no id, they are stored under
+// the id "legacy-" followed by the start of their own HMAC.
+const (
+	apiKeyPrefix    = "tp_"
+	legacyKeyPrefix = "legacy-"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+var (
+	apiKeySecret []byte
+
+	// LEGACY_KEYS=migrate looks unknown passwords up among the bcrypt
+	// hashed key:<hash> entries of RegisterUser, moving the matching entry
+	// to the API key store. Each lookup costs a bcrypt comparison per
+	// remaining entry, so it is only enabled while migrating, a single
+	// lookup runs at a time and passwords that matched nothing are not
+	// looked up again for legacyMissTTL.
+	migrateLegacy = os.Getenv("LEGACY_KEYS") == "migrate"
+	migrateMutex  sync.Mutex
+
+	legacyMisses     = make(map[string]time.Time) // hashSecret of the password -> expiry
+	legacyMissMutex  sync.Mutex
+	errMigrationBusy = errors.New("legacy key lookup already in progress")
+)
+
+const (
+	legacyMissTTL   = 10 * time.Minute
+	maxLegacyMisses = 100000
+)
+
+// InitAPIKeys loads the HMAC key of API_KEY_SECRET, which must stay the same
+// for stored keys to verify.
+func InitAPIKeys() {
+	secret := os.Getenv("API_KEY_SECRET")
+	if secret == "" {
+		if os.Getenv("DEBUG_MODE") != "1" {
+			log.Fatal("API_KEY_SECRET is not set")
+		}
+		secret = "turbo-proxy-debug"
+	}
+	apiKeySecret = []byte(secret)
+}
+
+// CreateAPIKey stores a new account and returns its key, the key itself is
+// not kept and cannot be recovered.
+func CreateAPIKey(credits int, plainHTTP bool) (string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", err
+	}
+
+	if err := storeAPIKey(id, secret, credits, plainHTTP); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + id + "_" + secret, nil
+}
+
+func storeAPIKey(id, secret string, credits int, plainHTTP bool) error {
+	err := rdb.HSet(ctx, "apikey:"+id,
+		"secret", hashSecret(secret),
+		"credits", credits,
+		"plain_http", plainHTTP,
+	).Err()
+	if err != nil {
+		return fmt.Errorf("error storing api key: %v", err)
+	}
+	return nil
+}
+
+// GetAccount authenticates password, accounts validated in the last
+// authCacheTTL are served from memory, and for up to authStaleTTL when redis
+// fails.
+func GetAccount(password string) (*Account, error) {
+	id, secret := parseAPIKey(password)
+	if account, ok := accounts.get(id, secret, authCacheTTL); ok {
+		return account, nil
+	}
+
+	account, err := fetchAccount(id, secret, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return nil, err
+	}
+	if err != nil {
+		if account, ok := accounts.get(id, secret, authStaleTTL); ok {
+			log.Printf("Serving cached account %s: %v", id, err)
+			return account, nil
+		}
+		return nil, err
+	}
+
+	accounts.put(secret, account)
+	return account, nil
+}
+
+func fetchAccount(id, secret, password string) (*Account, error) {
+	fields, err := rdb.HGetAll(ctx, "apikey:"+id).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving account: %v", err)
+	}
+	if len(fields) == 0 && strings.HasPrefix(id, legacyKeyPrefix) && migrateLegacy {
+		if fields, err = migrateLegacyKey(id, password); err != nil {
+			return nil, err
+		}
+	}
+	if len(fields) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	if !hmac.Equal([]byte(fields["secret"]), []byte(hashSecret(secret))) {
+		return nil, ErrInvalidCredentials
+	}
+
+	credits, _ := strconv.Atoi(fields["credits"])
+	plainHTTP, _ := strconv.ParseBool(fields["plain_http"])
+
+	var limits Limits
+	limits.MaxConns, _ = strconv.Atoi(fields["max_conns"])
+	limits.Bandwidth, _ = strconv.ParseInt(fields["bandwidth"], 10, 64)
+	limits.RequestsPerMinute, _ = strconv.Atoi(fields["requests_per_minute"])
+
+	return &Account{
+		ID:        id,
+		Credits:   credits,
+		PlainHTTP: plainHTTP,
+		Limits:    limits,
+		key:       "apikey:" + id,
+	}, nil
+}
+
+// parseAPIKey splits an API key into its id and secret, other passwords are
+// legacy keys
+func parseAPIKey(password string) (id, secret string) {
+	if rest, ok := strings.CutPrefix(password, apiKeyPrefix); ok {
+		if id, secret, ok := strings.Cut(rest, "_"); ok && id != "" && secret != "" {
+			return id, secret
+		}
+	}
+	return legacyKeyPrefix + hashSecret(password)[:16], password
+}
+
+// migrateLegacyKey moves the key:<bcrypt hash> entry of password to
+// apikey:<id> and returns its fields, which are empty if there is none.
+// Lookups do not queue up, errMigrationBusy is returned while another one
+// runs.
+func migrateLegacyKey(id, password string) (map[string]string, error) {
+	miss := hashSecret(password)
+	if knownLegacyMiss(miss) {
+		return nil, nil
+	}
+
+	if !migrateMutex.TryLock() {
+		return nil, errMigrationBusy
+	}
+	defer migrateMutex.Unlock()
+
+	// migrated while waiting for the lock
+	if fields, err := rdb.HGetAll(ctx, "apikey:"+id).Result(); err != nil || len(fields) > 0 {
+		return fields, err
+	}
+
+	iter := rdb.Scan(ctx, 0, "key:*", 100).Iterator()
+	for iter.Next(ctx) {
+		legacy := iter.Val()
+		hash := strings.TrimPrefix(legacy, "key:")
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			continue
+		}
+
+		credits, err := rdb.HGet(ctx, legacy, "credits").Int()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, fmt.Errorf("error retrieving legacy key: %v", err)
+		}
+		plainHTTP, _ := rdb.HGet(ctx, legacy, "plain_http").Bool()
+
+		if err := storeAPIKey(id, password, credits, plainHTTP); err != nil {
+			return nil, err
+		}
+		rdb.Del(ctx, legacy)
+		log.Printf("Migrated legacy key to account %s", id)
+
+		return rdb.HGetAll(ctx, "apikey:"+id).Result()
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning legacy keys: %v", err)
+	}
+
+	recordLegacyMiss(miss)
+	return nil, nil
+}
+
+func knownLegacyMiss(miss string) bool {
+	legacyMissMutex.Lock()
+	defer legacyMissMutex.Unlock()
+
+	expiry, ok := legacyMisses[miss]
+	return ok && time.Now().Before(expiry)
+}
+
+func recordLegacyMiss(miss string) {
+	legacyMissMutex.Lock()
+	defer legacyMissMutex.Unlock()
+
+	now := time.Now()
+	if len(legacyMisses) >= maxLegacyMisses {
+		for other, expiry := range legacyMisses {
+			if now.After(expiry) {
+				delete(legacyMisses, other)
+			}
+		}
+	}
+	if len(legacyMisses) < maxLegacyMisses {
+		legacyMisses[miss] = now.Add(legacyMissTTL)
+	}
+}
+
+func hashSecret(secret string) string {
+	mac := hmac.New(sha256.New, apiKeySecret)
+	mac.Write([]byte(secret))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
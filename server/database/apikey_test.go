@@ -0,0 +1,33 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAPIKey(t *testing.T) {
+	tests := []struct {
+		password   string
+		id, secret string
+	}{
+		{"tp_0123abcd_s3cr3t", "0123abcd", "s3cr3t"},
+		{"tp_0123abcd_s3cr_3t", "0123abcd", "s3cr_3t"},
+	}
+	for _, tt := range tests {
+		id, secret := parseAPIKey(tt.password)
+		if id != tt.id || secret != tt.secret {
+			t.Errorf("parseAPIKey(%q) = %q, %q, want %q, %q", tt.password, id, secret, tt.id, tt.secret)
+		}
+	}
+
+	// anything else is a legacy key, identified by its HMAC
+	for _, password := range []string{"hunter2", "tp_", "tp_id", "tp__secret", "tp_id_", "TP_id_secret"} {
+		id, secret := parseAPIKey(password)
+		if !strings.HasPrefix(id, legacyKeyPrefix) || secret != password {
+			t.Errorf("parseAPIKey(%q) = %q, %q, want a legacy key", password, id, secret)
+		}
+		if other, _ := parseAPIKey(password + "x"); other == id {
+			t.Errorf("legacy keys %q and %q share the id %q", password, password+"x", id)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	authCacheSize = 10000
+	authCacheTTL  = 30 * time.Second
+	// authStaleTTL bounds how long a cached account keeps authenticating
+	// while redis cannot be reached
+	authStaleTTL = 5 * time.Minute
+
+	// InvalidateChannel receives the id of an account whose credits or
+	// permissions changed outside of the servers, such as a top-up by the
+	// payment gateway, "*" drops every cached account
+	InvalidateChannel = "account:invalidate"
+)
+
+// authCache keeps recently validated accounts, keyed by account id. An entry
+// only matches the password whose HMAC it holds.
+type authCache struct {
+	mutex   sync.Mutex
+	entries map[string]*list.Element // account id -> *authEntry
+	lru     *list.List               // most recently used first
+}
+
+type authEntry struct {
+	secret  string // hashSecret of the secret
+	account Account
+	fetched time.Time
+}
+
+var accounts = &authCache{
+	entries: make(map[string]*list.Element),
+	lru:     list.New(),
+}
+
+// get returns the cached account of id if secret matches and it is not
+// older than maxAge
+func (c *authCache) get(id, secret string, maxAge time.Duration) (*Account, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*authEntry)
+	if time.Since(entry.fetched) > maxAge || !hmac.Equal([]byte(entry.secret), []byte(hashSecret(secret))) {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	account := entry.account
+	return &account, true
+}
+
+func (c *authCache) put(secret string, account *Account) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := &authEntry{secret: hashSecret(secret), account: *account, fetched: time.Now()}
+	if element, ok := c.entries[account.ID]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[account.ID] = c.lru.PushFront(entry)
+	if c.lru.Len() > authCacheSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*authEntry).account.ID)
+	}
+}
+
+// setCredits records a balance read from redis without refreshing the entry
+func (c *authCache) setCredits(id string, credits int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[id]; ok {
+		element.Value.(*authEntry).account.Credits = credits
+	}
+}
+
+func (c *authCache) invalidate(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if id == "*" {
+		c.entries = make(map[string]*list.Element)
+		c.lru.Init()
+		return
+	}
+	if element, ok := c.entries[id]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, id)
+	}
+}
+
+// WatchInvalidations applies the messages of InvalidateChannel to the auth
+// cache. Messages sent while the subscription was down are lost, so the whole
+// cache is dropped each time it is established.
+func WatchInvalidations() {
+	pubsub := rdb.Subscribe(ctx, InvalidateChannel)
+	defer pubsub.Close()
+
+	for {
+		msg, err := pubsub.Receive(ctx)
+		if err != nil {
+			log.Printf("Account invalidation subscription failed: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			accounts.invalidate("*")
+		case *redis.Message:
+			accounts.invalidate(msg.Payload)
+		}
+	}
+}
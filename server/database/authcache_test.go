@@ -0,0 +1,67 @@
+package database
+
+import (
+	"container/list"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestCache() *authCache {
+	return &authCache{entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func TestAuthCacheGet(t *testing.T) {
+	c := newTestCache()
+	c.put("secret", &Account{ID: "a", Credits: 10})
+
+	if account, ok := c.get("a", "secret", time.Minute); !ok || account.Credits != 10 {
+		t.Fatalf("got %+v, %v", account, ok)
+	}
+	if _, ok := c.get("a", "wrong", time.Minute); ok {
+		t.Fatal("cached account matched another secret")
+	}
+	if _, ok := c.get("a", "secret", 0); ok {
+		t.Fatal("cached account outlived its max age")
+	}
+
+	c.setCredits("a", 5)
+	if account, _ := c.get("a", "secret", time.Minute); account.Credits != 5 {
+		t.Fatalf("credits are %d after setCredits, want 5", account.Credits)
+	}
+
+	c.invalidate("a")
+	if _, ok := c.get("a", "secret", time.Minute); ok {
+		t.Fatal("invalidated account still cached")
+	}
+}
+
+func TestAuthCacheEviction(t *testing.T) {
+	c := newTestCache()
+	for i := range authCacheSize {
+		c.put("secret", &Account{ID: strconv.Itoa(i)})
+	}
+
+	// the oldest entry is used again, the second oldest is evicted instead
+	if _, ok := c.get("0", "secret", time.Minute); !ok {
+		t.Fatal("oldest account was not cached")
+	}
+	c.put("secret", &Account{ID: "new"})
+
+	if c.lru.Len() != authCacheSize || len(c.entries) != authCacheSize {
+		t.Fatalf("cache holds %d entries and %d ids, want %d", c.lru.Len(), len(c.entries), authCacheSize)
+	}
+	if _, ok := c.get("1", "secret", time.Minute); ok {
+		t.Fatal("least recently used account was not evicted")
+	}
+	for _, id := range []string{"0", "2", "new"} {
+		if _, ok := c.get(id, "secret", time.Minute); !ok {
+			t.Fatalf("account %s was evicted", id)
+		}
+	}
+
+	c.invalidate("*")
+	if c.lru.Len() != 0 || len(c.entries) != 0 {
+		t.Fatal("invalidating every account left entries")
+	}
+}
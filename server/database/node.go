@@ -0,0 +1,52 @@
+package database
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrNodeExists = errors.New("node is already registered")
+
+// RegisterNode stores the identity of a new node paired with the account
+// uid, only a hash of its token is kept. An existing node is never moved to
+// another account.
+func RegisterNode(nodeID, uid, token string) error {
+	key := "node:" + nodeID
+	created, err := rdb.HSetNX(ctx, key, "owner", uid).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrNodeExists
+	}
+
+	return rdb.HSet(ctx, key,
+		"token", hashToken(token),
+		"paired", time.Now().Unix(),
+	).Err()
+}
+
+// VerifyNode reports whether token was issued to nodeID. Only storage
+// failures are returned as errors, unknown nodes are simply not verified.
+func VerifyNode(nodeID, token string) (bool, error) {
+	stored, err := rdb.HGet(ctx, "node:"+nodeID, "token").Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	hash := hashToken(token)
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -2,12 +2,13 @@ package database
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
 
 	"github.com/redis/go-redis/v9"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var (
@@ -26,35 +27,77 @@ func InitRedis() {
 	})
 }
 
+// RegisterUser stores password as a legacy key, new accounts should use
+// CreateAPIKey.
+//
 // Deprecated: distant registration
 func RegisterUser(password string, credits int) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return fmt.Errorf("error hashing password: %v", err)
-	}
+	id, secret := parseAPIKey(password)
+	return storeAPIKey(id, secret, credits, false)
+}
 
-	hashKey := string(hashedPassword)
-	rdb.HSet(ctx, "key:"+hashKey, "credits", credits)
+// ErrNoCredits rejects accounts whose balance is used up
+var ErrNoCredits = errors.New("insufficient credits")
 
-	return nil
+// Account is what a proxy key gives access to
+type Account struct {
+	ID        string // stable, derived from the key
+	Credits   int    // remaining balance in bytes relayed
+	PlainHTTP bool   // may forward unencrypted HTTP requests
+	Limits    Limits
+
+	key string // redis hash of the account, empty for unmetered accounts
 }
 
-func GetCredits(password string) (int, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// Limits of the plan of an account, zero is unlimited
+type Limits struct {
+	MaxConns          int   // concurrent connections and UDP associations
+	Bandwidth         int64 // bytes per second, both directions together
+	RequestsPerMinute int   // new connections and forwarded requests
+}
+
+// Metered tells whether the traffic of the account is charged
+func (a *Account) Metered() bool {
+	return a.key != ""
+}
+
+// ChargeCredits atomically takes bytes off the balance of the account and
+// returns what is left
+func (a *Account) ChargeCredits(bytes int64) (int64, error) {
+	if !a.Metered() {
+		return 0, errors.New("account is not metered")
+	}
+
+	credits, err := rdb.HIncrBy(ctx, a.key, "credits", -bytes).Result()
 	if err != nil {
-		return 0, fmt.Errorf("error hashing password: %v", err)
+		return 0, fmt.Errorf("error charging credits: %v", err)
 	}
+	accounts.setCredits(a.ID, int(credits))
+	return credits, nil
+}
 
-	hashKey := string(hashedPassword)
-	credits, err := rdb.HGet(ctx, "key:"+hashKey, "credits").Int()
+// FetchCredits reads the balance of the account from redis, bypassing the
+// auth cache
+func (a *Account) FetchCredits() (int64, error) {
+	if !a.Metered() {
+		return 0, errors.New("account is not metered")
+	}
 
+	credits, err := rdb.HGet(ctx, a.key, "credits").Int64()
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return 0, fmt.Errorf("invalid credentials")
-		} else {
-			return 0, fmt.Errorf("error retrieving credits: %v", err)
-		}
+		return 0, fmt.Errorf("error retrieving credits: %v", err)
 	}
-
+	accounts.setCredits(a.ID, int(credits))
 	return credits, nil
 }
+
+// AccountID identifies the account of a key without revealing it
+func AccountID(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:8])
+}
+
+// DebugAccount is granted every permission when DEBUG_MODE bypasses authentication
+func DebugAccount(password string) *Account {
+	return &Account{ID: AccountID(password), PlainHTTP: true}
+}
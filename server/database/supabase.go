@@ -37,3 +37,15 @@ func GetOrCreateUser(db *sqlx.DB, uid string) (*UserData, error) {
 	user = UserData{AuthUserId: uid, CreatedAt: now, UpdatedAt: now}
 	return &user, nil
 }
+
+// AddNode links a node to the account of the user running it
+func AddNode(db *sqlx.DB, uid string, nodeID string) error {
+	if _, err := GetOrCreateUser(db, uid); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err := db.Exec("INSERT INTO \"Node\" (\"id\", \"authUserId\", \"createdAt\", \"updatedAt\") VALUES ($1, $2, $3, $3) "+
+		"ON CONFLICT (\"id\") DO UPDATE SET \"authUserId\"=$2, \"updatedAt\"=$3", nodeID, uid, now)
+	return err
+}
@@ -3,13 +3,22 @@ package main
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
 	"log"
 	"math/big"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"server/database"
 	"server/proxy"
 	"server/website"
@@ -51,21 +60,108 @@ func generateTLSCert() tls.Certificate {
 	return cert
 }
 
+// loadTLSCert loads the certificate of TLS_CERT and TLS_KEY, nodes pin its
+// public key. Without them a self-signed certificate is generated once and
+// kept in TLS_DIR (tls by default), so that its pin holds across restarts.
+func loadTLSCert() tls.Certificate {
+	certFile, keyFile := os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			log.Fatal("Failed to load TLS certificate:", err)
+		}
+		return cert
+	}
+
+	dir := os.Getenv("TLS_DIR")
+	if dir == "" {
+		dir = "tls"
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		return cert
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		log.Fatal("Failed to load TLS certificate:", err)
+	}
+
+	log.Println("TLS_CERT and TLS_KEY are not set, generating a self-signed certificate in", dir)
+	cert := generateTLSCert()
+	if err := saveTLSCert(cert, certFile, keyFile); err != nil {
+		log.Printf("Failed to save the certificate, its pin changes on restart: %v", err)
+	}
+	return cert
+}
+
+func saveTLSCert(cert tls.Certificate, certFile, keyFile string) error {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0o700); err != nil {
+		return err
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	return os.WriteFile(certFile, certPEM, 0o644)
+}
+
+// publicKeyPin returns the tls_pin of cert for the configuration of nodes
+func publicKeyPin(cert tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	hash := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// createKey prints a new API key: server create-key -credits <bytes> [-plain-http]
+func createKey(args []string) {
+	flags := flag.NewFlagSet("create-key", flag.ExitOnError)
+	credits := flags.Int("credits", 0, "balance in bytes relayed")
+	plainHTTP := flags.Bool("plain-http", false, "allow forwarding unencrypted HTTP requests")
+	flags.Parse(args)
+
+	key, err := database.CreateAPIKey(*credits, *plainHTTP)
+	if err != nil {
+		log.Fatal("Failed to create API key:", err)
+	}
+	fmt.Println(key)
+}
+
 func main() {
 	database.InitRedis()
+	database.InitAPIKeys()
+
+	if len(os.Args) > 1 && os.Args[1] == "create-key" {
+		createKey(os.Args[2:])
+		return
+	}
+
+	go database.WatchInvalidations()
+	proxy.InitGeolocation()
+	proxy.InitPolicy()
 
 	http.Handle("/metrics", promhttp.Handler())
 	http.HandleFunc("/stats", website.StatsHandler)
+	http.HandleFunc("/pair", website.PairHandler)
 	go func() {
 		if err := http.ListenAndServe(":8080", nil); err != nil {
 			log.Fatal("Failed to start Prometheus metrics endpoint:", err)
 		}
 	}()
 
+	cert := loadTLSCert()
+	log.Println("TLS public key pin, the tls_pin of nodes:", publicKeyPin(cert))
+
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: true, // Skip verification for self-signed cert
-		Certificates:       []tls.Certificate{generateTLSCert()},
-		NextProtos:         []string{"turbo-proxy"}, // Application protocol
+		Certificates:       []tls.Certificate{cert},
+		NextProtos:         proxy.NextProtos(), // Application protocols
 	}
 	log.Println("Starting QUIC server on :8443")
 	err := proxy.StartQuicServer(":8443", tlsConfig)
@@ -73,6 +169,17 @@ func main() {
 		log.Fatal("Failed to start QUIC server:", err)
 	}
 
+	httpProxyAddr := os.Getenv("HTTP_PROXY_ADDR")
+	if httpProxyAddr == "" {
+		httpProxyAddr = ":8081"
+	}
+	log.Println("Starting HTTP proxy on", httpProxyAddr)
+	go func() {
+		if err := http.ListenAndServe(httpProxyAddr, &proxy.HTTPProxy{}); err != nil {
+			log.Fatal("Failed to start HTTP proxy:", err)
+		}
+	}()
+
 	log.Println("Starting SOCKS5 receiver on :1080")
 	listener, err := net.Listen("tcp", ":1080")
 	if err != nil {
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Availability is announced by nodes whose runner limits sharing with data
+// caps or a schedule, users are only routed to nodes that share
+type Availability struct {
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+func (c *QuicClient) setAvailability(payload []byte) {
+	var availability Availability
+	if err := json.Unmarshal(payload, &availability); err != nil {
+		log.Printf("Invalid availability from client %s: %v", c.nodeID(), err)
+		return
+	}
+
+	var previous *string
+	if availability.Available {
+		previous = c.unavailable.Swap(nil)
+		if previous == nil {
+			return
+		}
+		log.Printf("Client %s resumed sharing", c.nodeID())
+	} else {
+		reason := availability.Reason
+		if reason == "" {
+			reason = "unknown"
+		}
+		previous = c.unavailable.Swap(&reason)
+		if previous != nil {
+			return // already out of the pools
+		}
+		log.Printf("Client %s paused sharing: %s", c.nodeID(), reason)
+	}
+
+	updatePools()
+}
+
+// Unavailable returns why the node does not share, or "" while it shares
+func (c *QuicClient) Unavailable() string {
+	if reason := c.unavailable.Load(); reason != nil {
+		return *reason
+	}
+	return ""
+}
@@ -3,69 +3,155 @@ package proxy
 import (
 	"log"
 	"math/rand"
+	"server/proxy/user"
 	"sort"
 	"sync"
 )
 
 var (
-	// Lock-free reads with sync.Map
-	countryClients sync.Map // 2-digit country code -> *CountryPool
-	globalClients  sync.Map // "global" -> *CountryPool
+	// Lock-free reads with sync.Map, keyed by poolKey
+	pools sync.Map // pool key -> *ClientPool
 
 	updateMutex sync.RWMutex
 )
 
-type CountryPool struct {
+// ClientPool holds the healthy nodes of one location, from "global" down to
+// a single city or ISP.
+type ClientPool struct {
 	clients           []*QuicClient
 	cumulativeWeights []float64 // Pre-computed for O(log n) selection
 	totalWeight       float64
 	lastUpdated       int64
 }
 
+// Pool keys, region and city names are normalized with user.NormalizeLocation:
+//
+//	global                          every node
+//	US                              country
+//	region:US/california            region of a country
+//	city:US/california/los angeles  city
+//	city:US//los angeles            city regardless of the region
+//	asn:7922, asn:US/7922           ISP, worldwide or within a country
+func regionKey(country, region string) string {
+	return "region:" + country + "/" + region
+}
+
+func cityKey(country, region, city string) string {
+	return "city:" + country + "/" + region + "/" + city
+}
+
+func asnKey(country, asn string) string {
+	if country == "global" {
+		return "asn:" + asn
+	}
+	return "asn:" + country + "/" + asn
+}
+
+// poolKeys lists every pool a node belongs to
+func poolKeys(stats *ClientStats) []string {
+	keys := []string{"global"}
+
+	country := stats.CountryCode
+	if country == "" || country == "global" {
+		if stats.ASN != "" {
+			keys = append(keys, asnKey("global", stats.ASN))
+		}
+		return keys
+	}
+	keys = append(keys, country)
+
+	region, city := user.NormalizeLocation(stats.Region), user.NormalizeLocation(stats.City)
+	if region != "" {
+		keys = append(keys, regionKey(country, region))
+	}
+	if city != "" {
+		keys = append(keys, cityKey(country, "", city))
+		if region != "" {
+			keys = append(keys, cityKey(country, region, city))
+		}
+	}
+	if stats.ASN != "" {
+		keys = append(keys, asnKey("global", stats.ASN), asnKey(country, stats.ASN))
+	}
+	return keys
+}
+
 func FindClient() *QuicClient {
 	if client := FindClientByCountry("global"); client != nil {
 		return client
 	} else {
 		// Logs pool sizes for debugging
 		globalPoolSize := 0
-		if pool, ok := globalClients.Load("global"); ok {
-			globalPool := pool.(*CountryPool)
-			globalPoolSize = len(globalPool.clients)
+		if pool := loadPool("global"); pool != nil {
+			globalPoolSize = len(pool.clients)
 		}
 
-		countryPoolSize := 0
-		countryClients.Range(func(key, value any) bool {
-			pool := value.(*CountryPool)
-			countryPoolSize += len(pool.clients)
+		poolCount := 0
+		pools.Range(func(key, value any) bool {
+			poolCount++
 			return true
 		})
 
-		log.Printf("DEBUG: No healthy clients found. Global pool size: %d, Country pool size: %d", globalPoolSize, countryPoolSize)
+		log.Printf("DEBUG: No healthy clients found. Global pool size: %d, Pools: %d", globalPoolSize, poolCount)
 		return nil
 	}
 }
 
+// FindClientByCountry picks a node from the pool of a country code or any
+// other pool key
 func FindClientByCountry(countryCode string) *QuicClient {
-	var pool interface{}
-	var ok bool
+	if pool := loadPool(countryCode); pool != nil {
+		if client := selectFromPool(pool); client != nil {
+			return client
+		}
+	}
 
-	if countryCode == "global" {
-		pool, ok = globalClients.Load(countryCode)
-	} else {
-		pool, ok = countryClients.Load(countryCode)
+	return nil
+}
+
+// FindClientWithCapability is FindClientByCountry restricted to nodes that
+// advertise capability
+func FindClientWithCapability(countryCode, capability string) *QuicClient {
+	return findClient(countryCode, func(client *QuicClient) bool {
+		return client.HasCapability(capability)
+	})
+}
+
+// findClient picks a node of the pool key that accept allows
+func findClient(key string, accept func(*QuicClient) bool) *QuicClient {
+	pool := loadPool(key)
+	if pool == nil {
+		return nil
 	}
 
-	if ok {
-		countryPool := pool.(*CountryPool)
-		if client := selectFromPool(countryPool); client != nil {
+	for attempts := 0; attempts < 3; attempts++ {
+		if client := selectFromPool(pool); client != nil && accept(client) {
 			return client
 		}
 	}
 
-	return nil
+	// accepted nodes may be rare in the pool, fall back to a uniform pick among them
+	var accepted []*QuicClient
+	for _, client := range pool.clients {
+		if client.isHealthy() && accept(client) {
+			accepted = append(accepted, client)
+		}
+	}
+	if len(accepted) == 0 {
+		return nil
+	}
+	return accepted[rand.Intn(len(accepted))]
 }
 
-func selectFromPool(pool *CountryPool) *QuicClient {
+func loadPool(key string) *ClientPool {
+	pool, ok := pools.Load(key)
+	if !ok {
+		return nil
+	}
+	return pool.(*ClientPool)
+}
+
+func selectFromPool(pool *ClientPool) *QuicClient {
 	if pool.totalWeight == 0 || len(pool.clients) == 0 {
 		return nil
 	}
@@ -88,60 +174,58 @@ func selectFromPool(pool *CountryPool) *QuicClient {
 	return nil // All attempts failed
 }
 
+// isHealthy tells whether the node can take new connections
 func (c *QuicClient) isHealthy() bool {
-	return c != nil && c.conn != nil
+	return c != nil && c.conn != nil && !c.kicked.Load() && c.conn.Context().Err() == nil &&
+		c.unavailable.Load() == nil
 }
 
 func updatePools() {
 	updateMutex.Lock()
 	defer updateMutex.Unlock()
 
-	var globalPool CountryPool
+	QuicMutex.RLock()
+	clientSnapshot := make([]*QuicClient, 0, len(QuicClients))
 	for _, client := range QuicClients {
-		if client.isHealthy() {
-			weight := client.Metrics.Score
-			if weight < 1 {
-				weight = 1
-			}
-			globalPool.clients = append(globalPool.clients, client)
-			if len(globalPool.cumulativeWeights) == 0 {
-				globalPool.cumulativeWeights = append(globalPool.cumulativeWeights, weight)
-			} else {
-				cumWeight := globalPool.cumulativeWeights[len(globalPool.cumulativeWeights)-1]
-				globalPool.cumulativeWeights = append(globalPool.cumulativeWeights, cumWeight+weight)
-			}
-			globalPool.totalWeight += weight
-		}
+		clientSnapshot = append(clientSnapshot, client)
 	}
-	globalClients.Store("global", &globalPool)
+	QuicMutex.RUnlock()
 
-	countryMap := make(map[string]*CountryPool)
-	for _, client := range QuicClients {
-		if client.isHealthy() {
-			country := client.Stats.CountryCode
-			if country == "global" {
-				continue
-			}
+	poolMap := make(map[string]*ClientPool)
+	poolMap["global"] = &ClientPool{}
+	for _, client := range clientSnapshot {
+		if !client.isHealthy() {
+			continue
+		}
 
-			if _, exists := countryMap[country]; !exists {
-				countryMap[country] = &CountryPool{}
-			}
-			pool := countryMap[country]
-			weight := client.Metrics.Score
-			if weight < 1 {
-				weight = 1
-			}
-			pool.clients = append(pool.clients, client)
-			if len(pool.cumulativeWeights) == 0 {
-				pool.cumulativeWeights = append(pool.cumulativeWeights, weight)
-			} else {
-				cumWeight := pool.cumulativeWeights[len(pool.cumulativeWeights)-1]
-				pool.cumulativeWeights = append(pool.cumulativeWeights, cumWeight+weight)
+		weight := client.Metrics.Score
+		if weight < 1 {
+			weight = 1
+		}
+		for _, key := range poolKeys(client.Stats) {
+			pool, exists := poolMap[key]
+			if !exists {
+				pool = &ClientPool{}
+				poolMap[key] = pool
 			}
-			pool.totalWeight += weight
+			pool.add(client, weight)
 		}
 	}
-	for country, pool := range countryMap {
-		countryClients.Store(country, pool)
+
+	for key, pool := range poolMap {
+		pools.Store(key, pool)
 	}
+	// drop locations whose last node left
+	pools.Range(func(key, value any) bool {
+		if _, exists := poolMap[key.(string)]; !exists {
+			pools.Delete(key)
+		}
+		return true
+	})
+}
+
+func (p *ClientPool) add(client *QuicClient, weight float64) {
+	p.clients = append(p.clients, client)
+	p.totalWeight += weight
+	p.cumulativeWeights = append(p.cumulativeWeights, p.totalWeight)
 }
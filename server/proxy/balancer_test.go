@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPoolKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats ClientStats
+		want  []string
+	}{
+		{
+			name:  "not geolocated",
+			stats: ClientStats{},
+			want:  []string{"global"},
+		},
+		{
+			name:  "ISP only",
+			stats: ClientStats{CountryCode: "global", ASN: "7922"},
+			want:  []string{"global", "asn:7922"},
+		},
+		{
+			name:  "country",
+			stats: ClientStats{CountryCode: "FR"},
+			want:  []string{"global", "FR"},
+		},
+		{
+			name:  "city without region",
+			stats: ClientStats{CountryCode: "SG", City: "Singapore"},
+			want:  []string{"global", "SG", "city:SG//singapore"},
+		},
+		{
+			name:  "every location",
+			stats: ClientStats{CountryCode: "US", Region: "California", City: "Los Angeles", ASN: "7922"},
+			want: []string{
+				"global", "US",
+				"region:US/california",
+				"city:US//los angeles", "city:US/california/los angeles",
+				"asn:7922", "asn:US/7922",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := poolKeys(&tt.stats); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// connectAttempts is how many nodes a connection is tried on
+const connectAttempts = 3
+
+// Reasons a node gives in connect_error frames
+const (
+	ConnectRefused     = "refused"
+	ConnectUnreachable = "unreachable"
+	ConnectDNS         = "dns"
+	ConnectTimeout     = "timeout"
+	ConnectDenied      = "denied"
+	ConnectFailed      = "failed"
+	// ConnectUnavailable comes from a node that stopped sharing before its
+	// announcement reached the server
+	ConnectUnavailable = "unavailable"
+)
+
+// connectError is reported by a node that could not reach the target
+type connectError struct {
+	reason string
+}
+
+func (e *connectError) Error() string {
+	return "node reported " + e.reason
+}
+
+// retryable is false when another node would fail the same way
+func (e *connectError) retryable() bool {
+	return e.reason != ConnectRefused && e.reason != ConnectDNS && e.reason != ConnectDenied
+}
+
+var (
+	errNoClients      = errors.New("no available node")
+	errConnectTimeout = errors.New("node did not connect in time")
+	errConnectFailed  = errors.New("node could not reach the target")
+)
+
+// connectWithFailover asks the nodes picked by sel to connect to addr until
+// one confirms it reached the target, each node is tried once. The error of
+// the last attempt is returned when every node failed, destinations refused
+// by the policy are never sent to a node.
+func connectWithFailover(pc *Connection, sel selector, addr string) (*QuicClient, tunnel, error) {
+	if err := checkDestination(sel.account, addr); err != nil {
+		return nil, nil, err
+	}
+
+	err := errNoClients
+	tried := make(map[string]bool, connectAttempts)
+	for attempt := 0; attempt < connectAttempts; attempt++ {
+		client := sel.pick("", tried)
+		if client == nil {
+			break
+		}
+		tried[client.ID] = true
+
+		var t tunnel
+		t, err = client.connect(pc, addr)
+		if err == nil {
+			sel.pin(client)
+			return client, t, nil
+		}
+		log.Printf("Connection to %s failed on client %s: %v", addr, client.ID, err)
+		sel.sticky = nil // fail over without moving the session
+
+		var ce *connectError
+		if errors.As(err, &ce) && !ce.retryable() {
+			break
+		}
+	}
+
+	return nil, nil, err
+}
+
+// connect opens a tunnel and waits for the node to confirm the connection
+func (c *QuicClient) connect(pc *Connection, addr string) (tunnel, error) {
+	t, err := c.openTunnel(pc, addr)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, errConnectTimeout
+		}
+		return nil, err
+	}
+
+	t.SetReadDeadline(time.Now().Add(connectTimeout))
+	msg, err := readFrame(t)
+	t.SetReadDeadline(time.Time{})
+
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		err = errConnectTimeout
+	case err != nil:
+		err = errConnectFailed
+	case msg.Type == MsgConnectError:
+		err = &connectError{reason: string(msg.Data)}
+	case msg.Type != MsgConnected:
+		err = fmt.Errorf("unexpected %s frame instead of connect confirmation", msg.Type)
+	default:
+		return t, nil
+	}
+
+	c.detachConnection(pc.ID)
+	return nil, err
+}
@@ -3,26 +3,24 @@ package proxy
 import (
 	"net"
 	"server/data"
-	"strconv"
+	"sync/atomic"
 	"time"
 )
 
 type Connection struct {
-	ID       string
+	ID       uint32
 	Conn     net.Conn
-	DataChan chan []byte
 	Features *data.ConnectionFeatures
+	tunnel   tunnel
+	usage    *usage // charged for the relayed traffic, nil if unmetered
 }
 
-var nextID int
+var nextID atomic.Uint32
 
 func CreateConnection(conn net.Conn) *Connection {
-	dataChan := make(chan []byte, 100)
-	nextID++
 	return &Connection{
-		ID:       strconv.Itoa(nextID),
-		Conn:     conn,
-		DataChan: dataChan,
+		ID:   nextID.Add(1),
+		Conn: conn,
 		Features: &data.ConnectionFeatures{
 			StartTime: time.Now(),
 			Protocol:  conn.RemoteAddr().Network(),
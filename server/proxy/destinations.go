@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"server/proxy/policy"
+	"strconv"
+	"time"
+)
+
+const policyReloadInterval = 10 * time.Second
+
+var destinations *policy.Engine
+
+// InitPolicy loads the destination policy from POLICY_FILE (default
+// policy.json) and reloads it when the file changes.
+func InitPolicy() {
+	path := os.Getenv("POLICY_FILE")
+	if path == "" {
+		path = "policy.json"
+	}
+
+	destinations = policy.NewEngine(path)
+	go destinations.Watch(policyReloadInterval)
+}
+
+// checkDestination tells whether account may reach addr, refusals are
+// *policy.Denial
+func checkDestination(account, addr string) error {
+	if destinations == nil {
+		return nil
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return &policy.Denial{Reason: "invalid address " + addr}
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return &policy.Denial{Reason: "invalid port " + portStr}
+	}
+	return destinations.Check(account, host, port)
+}
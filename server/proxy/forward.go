@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"server/database"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// forwardUserKey carries the forwardUser of a request to the dialer
+type forwardUserKey struct{}
+
+type forwardUser struct {
+	sel     selector
+	account *database.Account
+}
+
+// forwardProxy relays absolute-URI requests through a node picked by the
+// selector of the user found in their context. The request is sent in origin-form and hop-by-hop headers are
+// dropped on the way, along with every Proxy-* header.
+var forwardProxy = &httputil.ReverseProxy{
+	Rewrite: func(r *httputil.ProxyRequest) {
+		r.Out.URL = r.In.URL
+		r.Out.Host = r.In.Host
+		for name := range r.Out.Header {
+			if strings.HasPrefix(name, "Proxy-") {
+				r.Out.Header.Del(name)
+			}
+		}
+	},
+	Transport: &http.Transport{
+		DialContext:        dialThroughNode,
+		DisableKeepAlives:  true, // connections are bound to the node of a single request
+		DisableCompression: true, // forward Accept-Encoding as sent by the user
+	},
+	ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("Forwarding %s failed: %v", r.URL.Host, err)
+		status, reason := connectStatus(err)
+		http.Error(w, reason, status)
+	},
+}
+
+// dialThroughNode checks the limits and credits of the user before asking a
+// node to connect
+func dialThroughNode(ctx context.Context, _, addr string) (net.Conn, error) {
+	user, ok := ctx.Value(forwardUserKey{}).(forwardUser)
+	if !ok {
+		return nil, errors.New("no user in request context")
+	}
+
+	pc := &Connection{ID: nextID.Add(1)}
+	conn := &tunnelConn{id: pc.ID}
+	var err error
+	conn.usage, conn.detach, err = trackUsage(user.account, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	client, t, err := connectWithFailover(pc, user.sel, addr)
+	if err != nil {
+		conn.detach()
+		return nil, err
+	}
+
+	conn.tunnel = t
+	conn.client.Store(client)
+	if conn.closed.Load() { // cut while the node was connecting
+		conn.Close()
+		return nil, database.ErrNoCredits
+	}
+	return conn, nil
+}
+
+// tunnelConn exposes a tunnel as a net.Conn to the HTTP transport. It is
+// registered with the usage of the user before the node is picked, client
+// is set once the node connected.
+type tunnelConn struct {
+	client atomic.Pointer[QuicClient]
+	id     uint32
+	tunnel tunnel
+	usage  *usage
+	detach func()
+	closed atomic.Bool
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	n, err := c.tunnel.Read(p)
+	c.usage.throttle(n)
+	atomic.AddUint64(&c.client.Load().Stats.BytesReceived, uint64(n))
+	c.usage.charge(n)
+	return n, err
+}
+
+func (c *tunnelConn) Write(p []byte) (int, error) {
+	c.usage.throttle(len(p))
+	n, err := c.tunnel.Write(p)
+	atomic.AddUint64(&c.client.Load().Stats.BytesSent, uint64(n))
+	c.usage.charge(n)
+	return n, err
+}
+
+func (c *tunnelConn) Close() error {
+	c.closed.Store(true)
+	if client := c.client.Load(); client != nil {
+		client.closeConnection(c.id)
+	}
+	c.detach()
+	return nil
+}
+
+func (c *tunnelConn) CloseWrite() error {
+	return c.tunnel.CloseWrite()
+}
+
+func (c *tunnelConn) LocalAddr() net.Addr {
+	return c.client.Load().conn.LocalAddr()
+}
+
+func (c *tunnelConn) RemoteAddr() net.Addr {
+	return c.client.Load().conn.RemoteAddr()
+}
+
+func (c *tunnelConn) SetDeadline(t time.Time) error {
+	return c.tunnel.SetDeadline(t)
+}
+
+func (c *tunnelConn) SetReadDeadline(t time.Time) error {
+	return c.tunnel.SetReadDeadline(t)
+}
+
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error {
+	return c.tunnel.SetWriteDeadline(t)
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"log"
+	"net/netip"
+	"os"
+	"server/proxy/geo"
+	"strings"
+	"time"
+)
+
+const geoReloadInterval = time.Minute
+
+var geolocator geo.Geolocator
+
+// InitGeolocation sets up node geolocation from GEOIP_DB, a comma separated
+// list of .mmdb files (default GeoLite2-City.mmdb,GeoLite2-ASN.mmdb), which
+// are reloaded when they change. GEOIP_FALLBACK=ip-api looks addresses
+// missing from the databases up on ip-api.com.
+func InitGeolocation() {
+	paths := os.Getenv("GEOIP_DB")
+	if paths == "" {
+		paths = "GeoLite2-City.mmdb,GeoLite2-ASN.mmdb"
+	}
+
+	mmdb := geo.OpenMMDB(strings.Split(paths, ",")...)
+	go mmdb.Watch(geoReloadInterval)
+
+	chain := geo.Chain{mmdb}
+	if os.Getenv("GEOIP_FALLBACK") == "ip-api" {
+		chain = append(chain, geo.NewIPAPI())
+	}
+	geolocator = chain
+}
+
+// geolocate returns the location of a node, "global" when it is unknown
+func geolocate(addr string) geo.Location {
+	addrPort, err := netip.ParseAddrPort(addr)
+	if err != nil || geolocator == nil {
+		return geo.Location{CountryCode: "global"}
+	}
+
+	location, err := geolocator.Lookup(addrPort.Addr().Unmap())
+	if err != nil {
+		log.Printf("Couldn't locate %s: %v", addrPort.Addr(), err)
+		return geo.Location{CountryCode: "global"}
+	}
+	if location.CountryCode == "" {
+		location.CountryCode = "global"
+	}
+	return location
+}
+
+func (s *ClientStats) setLocation(location geo.Location) {
+	s.CountryCode = location.CountryCode
+	s.Region = location.Region
+	s.City = location.City
+	s.ASN = location.ASN
+	s.ISP = location.ISP
+}
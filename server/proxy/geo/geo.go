@@ -0,0 +1,51 @@
+// Package geo locates node addresses for the balancer pools.
+package geo
+
+import (
+	"errors"
+	"net/netip"
+)
+
+var ErrNotFound = errors.New("address not found")
+
+// Location is where an address exits to the internet, every field is empty
+// when unknown. CountryCode is an upper-case ISO 3166 code and ASN the
+// number without the "AS" prefix.
+type Location struct {
+	CountryCode string
+	Region      string
+	City        string
+	ASN         string
+	ISP         string
+}
+
+type Geolocator interface {
+	Lookup(ip netip.Addr) (Location, error)
+}
+
+// Chain asks each geolocator in turn until one knows the country of the
+// address, a location without a country is only returned when none does.
+type Chain []Geolocator
+
+func (c Chain) Lookup(ip netip.Addr) (Location, error) {
+	var partial *Location
+	err := ErrNotFound
+	for _, geolocator := range c {
+		location, lookupErr := geolocator.Lookup(ip)
+		if lookupErr != nil {
+			err = lookupErr
+			continue
+		}
+		if location.CountryCode != "" {
+			return location, nil
+		}
+		if partial == nil {
+			partial = &location
+		}
+	}
+
+	if partial != nil {
+		return *partial, nil
+	}
+	return Location{}, err
+}
@@ -0,0 +1,59 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"server/proxy/user"
+	"strings"
+	"time"
+)
+
+// IPAPI looks addresses up on ip-api.com. The free endpoint is rate limited
+// and plaintext, it is only meant as a fallback for addresses missing from
+// the local database.
+type IPAPI struct {
+	client *http.Client
+}
+
+func NewIPAPI() *IPAPI {
+	return &IPAPI{client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (g *IPAPI) Lookup(ip netip.Addr) (Location, error) {
+	resp, err := g.client.Get("http://ip-api.com/json/" + ip.String() + "?fields=status,countryCode,regionName,city,as,isp")
+	if err != nil {
+		return Location{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Location{}, fmt.Errorf("ip-api: %s", resp.Status)
+	}
+
+	var result struct {
+		Status      string `json:"status"`
+		CountryCode string `json:"countryCode"`
+		RegionName  string `json:"regionName"`
+		City        string `json:"city"`
+		AS          string `json:"as"` // "AS15169 Google LLC"
+		ISP         string `json:"isp"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Location{}, fmt.Errorf("ip-api: %w", err)
+	}
+	if result.Status != "success" || !user.IsValidCountryCode(result.CountryCode) {
+		return Location{}, ErrNotFound
+	}
+
+	location := Location{
+		CountryCode: strings.ToUpper(result.CountryCode),
+		Region:      result.RegionName,
+		City:        result.City,
+		ISP:         result.ISP,
+	}
+	if asn, _, _ := strings.Cut(result.AS, " "); asn != "" {
+		location.ASN, _ = user.ParseASN(asn)
+	}
+	return location, nil
+}
@@ -0,0 +1,145 @@
+package geo
+
+import (
+	"errors"
+	"log"
+	"net/netip"
+	"os"
+	"server/proxy/user"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// MMDB reads MaxMind or DB-IP databases in the .mmdb format. City and ASN
+// data usually ship as separate files, the lookup merges every file.
+type MMDB struct {
+	paths   []string
+	mutex   sync.RWMutex
+	readers []*mmdbFile
+}
+
+type mmdbFile struct {
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+}
+
+// Fields shared by the GeoIP2/GeoLite2 and DB-IP City, ASN and ISP databases
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASN          uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+	ISP          string `maxminddb:"isp"`
+}
+
+// OpenMMDB opens the databases at paths. A missing or broken file is logged
+// and skipped, Watch picks it up once it is in place.
+func OpenMMDB(paths ...string) *MMDB {
+	m := &MMDB{paths: paths, readers: make([]*mmdbFile, len(paths))}
+	m.reload(true)
+	return m
+}
+
+// Watch reopens the databases whose file changed, checking every interval.
+// Updaters like geoipupdate replace the file with a rename, so a changed file
+// is always complete.
+func (m *MMDB) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.reload(false)
+	}
+}
+
+// reload opens the files that changed, a file that disappears keeps being
+// served from the previous copy
+func (m *MMDB) reload(initial bool) {
+	for i, path := range m.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			if initial || !errors.Is(err, os.ErrNotExist) {
+				log.Printf("GeoIP database %s unavailable: %v", path, err)
+			}
+			continue
+		}
+
+		current := m.current(i)
+		if current != nil && current.modTime.Equal(info.ModTime()) && current.size == info.Size() {
+			continue
+		}
+
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			log.Printf("Failed to open GeoIP database %s: %v", path, err)
+			continue
+		}
+
+		m.mutex.Lock()
+		m.readers[i] = &mmdbFile{reader: reader, modTime: info.ModTime(), size: info.Size()}
+		m.mutex.Unlock()
+		if current != nil {
+			current.reader.Close()
+		}
+		log.Printf("Loaded GeoIP database %s (%s, built %s)", path, reader.Metadata.DatabaseType, reader.Metadata.BuildTime().Format(time.DateOnly))
+	}
+}
+
+func (m *MMDB) current(i int) *mmdbFile {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.readers[i]
+}
+
+func (m *MMDB) Lookup(ip netip.Addr) (Location, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var location Location
+	for _, file := range m.readers {
+		if file == nil {
+			continue
+		}
+
+		var record mmdbRecord
+		if err := file.reader.Lookup(ip).Decode(&record); err != nil {
+			return Location{}, err
+		}
+
+		if code := strings.ToUpper(record.Country.ISOCode); location.CountryCode == "" && user.IsValidCountryCode(code) {
+			location.CountryCode = code
+		}
+		if location.Region == "" && len(record.Subdivisions) > 0 {
+			location.Region = record.Subdivisions[0].Names["en"]
+		}
+		if location.City == "" {
+			location.City = record.City.Names["en"]
+		}
+		if location.ASN == "" && record.ASN != 0 {
+			location.ASN = strconv.FormatUint(uint64(record.ASN), 10)
+		}
+		if location.ISP == "" {
+			location.ISP = record.ISP
+		}
+		if location.ISP == "" {
+			location.ISP = record.Organization
+		}
+	}
+
+	if location == (Location{}) {
+		return Location{}, ErrNotFound
+	}
+	return location, nil
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"server/database"
+	"slices"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// ProtocolVersion is bumped on every incompatible change of the node protocol
+	ProtocolVersion    = 3
+	minProtocolVersion = 3 // 3 confirms connections with a connected frame
+
+	helloTimeout = 10 * time.Second
+
+	closeRejected quic.ApplicationErrorCode = 2
+)
+
+// Reject codes sent to nodes that cannot join the network
+const (
+	RejectOutdated    = "protocol_outdated"
+	RejectUnsupported = "protocol_unsupported"
+	RejectBadHello    = "bad_hello"
+
+	// RejectUnauthorized tells the node to forget its identity and pair again
+	RejectUnauthorized = "unauthorized"
+	// RejectUnavailable is temporary, the node retries with the same identity
+	RejectUnavailable = "server_unavailable"
+)
+
+// NodeInfo is the payload of the hello message exchanged when a node connects
+type NodeInfo struct {
+	Protocol     int      `json:"protocol"`
+	Version      string   `json:"version,omitempty"`
+	OS           string   `json:"os,omitempty"`
+	Arch         string   `json:"arch,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// Hello is sent by the node, NodeID and Token are the identity issued at
+// pairing and are left empty by nodes that were never paired. Session is
+// issued in the hello of the server and presented by the node when it
+// reconnects.
+type Hello struct {
+	NodeInfo
+	NodeID  string `json:"node_id,omitempty"`
+	Token   string `json:"token,omitempty"`
+	Session string `json:"session,omitempty"`
+}
+
+type Reject struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// handshake reads the hello of a node, verifies its identity and answers
+// it, nodes that cannot be served are rejected and disconnected.
+func (c *QuicClient) handshake() error {
+	c.stream.SetReadDeadline(time.Now().Add(helloTimeout))
+	msg, err := c.codec.ReadMessage()
+	c.stream.SetReadDeadline(time.Time{})
+	if err != nil {
+		return fmt.Errorf("reading hello: %w", err)
+	}
+
+	if msg.Type != MsgHello {
+		return c.reject(RejectOutdated, "node does not send hello, update required")
+	}
+
+	var hello Hello
+	if err := json.Unmarshal(msg.Data, &hello); err != nil {
+		return c.reject(RejectBadHello, "invalid hello payload")
+	}
+	info := hello.NodeInfo
+
+	switch {
+	case info.Protocol < minProtocolVersion:
+		return c.reject(RejectOutdated, fmt.Sprintf("protocol %d is no longer supported, update required", info.Protocol))
+	case info.Protocol > ProtocolVersion:
+		return c.reject(RejectUnsupported, fmt.Sprintf("server only supports protocol up to %d", ProtocolVersion))
+	}
+
+	if hello.NodeID != "" {
+		ok, err := database.VerifyNode(hello.NodeID, hello.Token)
+		if err != nil {
+			log.Printf("Cannot verify node %s: %v", hello.NodeID, err)
+			return c.reject(RejectUnavailable, "node identity cannot be verified, try again later")
+		}
+		if !ok {
+			return c.reject(RejectUnauthorized, "unknown node or invalid token, pairing required")
+		}
+		c.ID = hello.NodeID
+		c.paired = true
+		c.resume(hello.Session)
+	}
+
+	reply, _ := json.Marshal(Hello{
+		NodeInfo: NodeInfo{Protocol: ProtocolVersion},
+		Session:  c.session,
+	})
+	if err := c.SendMessage(Message{Type: MsgHello, Data: reply}); err != nil {
+		return err
+	}
+
+	c.Info = &info
+	log.Printf("Client %s runs %s on %s/%s (protocol %d)", c.ID, info.Version, info.OS, info.Arch, info.Protocol)
+	return nil
+}
+
+func (c *QuicClient) reject(code, reason string) error {
+	payload, _ := json.Marshal(Reject{Code: code, Reason: reason})
+	c.SendMessage(Message{Type: MsgReject, Data: payload})
+	c.stream.Close()
+
+	// give the node a chance to read the reject before tearing down
+	select {
+	case <-c.conn.Context().Done():
+	case <-time.After(time.Second):
+	}
+	c.conn.CloseWithError(closeRejected, reason)
+	return fmt.Errorf("rejected (%s): %s", code, reason)
+}
+
+func (c *QuicClient) HasCapability(capability string) bool {
+	return c.Info != nil && slices.Contains(c.Info.Capabilities, capability)
+}
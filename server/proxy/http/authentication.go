@@ -10,41 +10,52 @@ import (
 	"strings"
 )
 
-func Authenticate(req *http.Request) (bool, map[string]string) {
+// Authenticate returns the account of the Proxy-Authorization header, a nil
+// account without an error means the header is missing or malformed.
+func Authenticate(req *http.Request) (*database.Account, map[string]string, error) {
 	authHeader := req.Header.Get("Proxy-Authorization")
 	if authHeader == "" {
-		return false, nil
+		return nil, nil, nil
 	}
 
 	if !strings.HasPrefix(authHeader, "Basic ") {
-		log.Println("Unsupported authentication method:", authHeader)
-		return false, nil
+		// the header carries credentials, only the scheme is logged and
+		// a header without one may be a bare key
+		if scheme, _, ok := strings.Cut(authHeader, " "); ok {
+			log.Printf("Unsupported authentication method: %.32q", scheme)
+		} else {
+			log.Println("Proxy-Authorization without an authentication method")
+		}
+		return nil, nil, nil
 	}
 
 	encoded := strings.TrimPrefix(authHeader, "Basic ")
 	decoded, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
 		log.Println("Failed to decode credentials:", err)
-		return false, nil
+		return nil, nil, nil
 	}
 
 	credentials := string(decoded)
 	parts := strings.SplitN(credentials, ":", 2)
 	if len(parts) != 2 {
 		log.Println("Invalid credentials format")
-		return false, nil
+		return nil, nil, nil
 	}
 
 	username, password := parts[0], parts[1]
 
-	credits, err := database.GetCredits(password)
-	_ = credits
-	// TODO: create local user struct to consume credits
-
-	if err != nil && os.Getenv("DEBUG_MODE") != "1" {
-		log.Println("Authentication failed")
-		return false, nil
+	account, err := database.GetAccount(password)
+	if err != nil {
+		if os.Getenv("DEBUG_MODE") != "1" {
+			log.Println("Authentication failed")
+			return nil, nil, err
+		}
+		account = database.DebugAccount(password)
+	}
+	if account.Metered() && account.Credits <= 0 {
+		return nil, nil, database.ErrNoCredits
 	}
 
-	return true, user.ParseParams(username)
+	return account, user.ParseParams(username), nil
 }
@@ -1,53 +1,56 @@
 package proxy
 
 import (
-	"encoding/base64"
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"server/database"
 	http2 "server/proxy/http"
-	"sync/atomic"
-	"time"
+	"server/proxy/policy"
 )
 
 type HTTPProxy struct {
 }
 
 func (p *HTTPProxy) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
-	valid, params := http2.Authenticate(req)
-	if !valid {
+	account, params, err := http2.Authenticate(req)
+	if account == nil {
 		wr.Header().Set("Proxy-Authenticate", "Basic realm=\"Turbo Proxy\"")
+		if errors.Is(err, database.ErrNoCredits) {
+			http.Error(wr, "Insufficient credits", http.StatusProxyAuthRequired)
+			return
+		}
 		http.Error(wr, "Proxy authentication required", http.StatusProxyAuthRequired)
 		return
 	}
 
-	country := "global"
-	if _, exists := params["country"]; exists {
-		country = params["country"]
-	}
-
-	for k, v := range req.Header {
-		log.Println("Header: ", k, ":", v)
-	}
+	sel := newSelector(account, params)
 
-	if req.Method != "CONNECT" {
-		http.Error(wr, "Non-HTTPS websites are blocked by default, contact support to unlock", http.StatusBadRequest)
-		return
+	if req.Method != http.MethodConnect {
+		if !account.PlainHTTP {
+			http.Error(wr, "Plain HTTP forwarding is not enabled for this account", http.StatusForbidden)
+			return
+		}
+		if !req.URL.IsAbs() || req.URL.Scheme != "http" {
+			http.Error(wr, "Only absolute http:// URLs can be forwarded", http.StatusBadRequest)
+			return
+		}
 	}
 
-	client := FindClientByCountry(country)
-	if client == nil {
-		log.Println("No active clients available in country:", country)
-		http.Error(wr, "No active clients available", http.StatusServiceUnavailable)
+	if req.Method != http.MethodConnect {
+		forwardProxy.ServeHTTP(wr, req.WithContext(context.WithValue(req.Context(), forwardUserKey{}, forwardUser{sel: sel, account: account})))
 		return
 	}
 
-	wr.WriteHeader(http.StatusOK)
 	hijacker, ok := wr.(http.Hijacker)
 	if !ok {
 		http.Error(wr, "Hijacking not supported", http.StatusInternalServerError)
 		return
 	}
-	conn, _, err := hijacker.Hijack()
+	conn, buffered, err := hijacker.Hijack()
 	if err != nil {
 		http.Error(wr, err.Error(), http.StatusServiceUnavailable)
 		return
@@ -56,28 +59,91 @@ func (p *HTTPProxy) ServeHTTP(wr http.ResponseWriter, req *http.Request) {
 
 	pc := CreateConnection(conn)
 
-	buffer := make([]byte, 32*1024)
-	var connData string
-	n, err := pc.Conn.Read(buffer)
+	var detach func()
+	pc.usage, detach, err = trackUsage(account, conn)
 	if err != nil {
+		log.Printf("CONNECT to %s refused: %v", req.Host, err)
+		status, reason := connectStatus(err)
+		writeConnectResponse(conn, status, reason)
 		return
 	}
-	if n > 0 {
-		connData = base64.StdEncoding.EncodeToString(buffer[:n])
-		pc.Features.Inbound[time.Since(pc.Features.StartTime).Microseconds()] += uint16(n)
+	defer detach()
+
+	client, t, err := connectWithFailover(pc, sel, req.Host)
+	if err != nil {
+		log.Printf("CONNECT to %s failed: %v", req.Host, err)
+		status, reason := connectStatus(err)
+		writeConnectResponse(conn, status, reason)
+		return
+	}
+
+	if err := writeConnectResponse(conn, http.StatusOK, ""); err != nil {
+		client.closeConnection(pc.ID)
+		return
+	}
+
+	// the user may have sent data right behind the request
+	if n := buffered.Reader.Buffered(); n > 0 {
+		early, _ := buffered.Reader.Peek(n)
+		if _, err := t.Write(early); err != nil {
+			client.closeConnection(pc.ID)
+			return
+		}
+		pc.usage.charge(n)
+	}
+
+	relay(client, pc, t)
+}
+
+// connectStatus maps a connectWithFailover error to the response of the proxy
+func connectStatus(err error) (int, string) {
+	var denial *policy.Denial
+	if errors.As(err, &denial) {
+		return http.StatusForbidden, "Destination " + denial.Error()
+	}
+
+	var le *limitError
+	if errors.As(err, &le) {
+		return http.StatusTooManyRequests, "Plan limit exceeded: " + le.Error()
+	}
+	if errors.Is(err, database.ErrNoCredits) {
+		return http.StatusPaymentRequired, "Insufficient credits"
+	}
+
+	var ce *connectError
+	if errors.As(err, &ce) {
+		switch ce.reason {
+		case ConnectRefused:
+			return http.StatusBadGateway, "Target refused the connection"
+		case ConnectUnreachable:
+			return http.StatusBadGateway, "Target is unreachable"
+		case ConnectDNS:
+			return http.StatusBadGateway, "Target hostname could not be resolved"
+		case ConnectTimeout:
+			return http.StatusGatewayTimeout, "Target did not answer in time"
+		case ConnectDenied:
+			return http.StatusForbidden, "Target is not allowed"
+		}
+	}
+
+	switch {
+	case errors.Is(err, errNoClients):
+		return http.StatusServiceUnavailable, "No active clients available"
+	case errors.Is(err, errConnectTimeout):
+		return http.StatusGatewayTimeout, "Target did not answer in time"
+	default:
+		return http.StatusBadGateway, "Target could not be reached"
+	}
+}
+
+func writeConnectResponse(conn net.Conn, status int, reason string) error {
+	response := fmt.Sprintf("HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	if reason != "" {
+		response += fmt.Sprintf("Content-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\n\r\n%s\n", len(reason)+1, reason)
+	} else {
+		response += "\r\n"
 	}
 
-	client.userMutex.Lock()
-	client.userConns[pc.ID] = pc
-	client.userMutex.Unlock()
-	atomic.AddInt32(&client.Stats.ActiveConns, 1)
-	client.SendMessage(Message{
-		Type: "connect",
-		ID:   pc.ID,
-		Addr: req.Host,
-		Data: connData,
-	})
-
-	go relayFromSocksToQuic(client, pc)
-	relayFromChanToSocks(client, pc)
+	_, err := conn.Write([]byte(response))
+	return err
 }
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// ProtocolLegacy is the ALPN identifier of the newline-delimited JSON
+// protocol of nodes released before ProtocolFrame.
+const ProtocolLegacy = "turbo-proxy"
+
+// NextProtos lists the ALPN identifiers the QUIC server accepts, preferred
+// first. Legacy nodes are still accepted so that they get a reject they log,
+// instead of a TLS handshake failure they cannot explain.
+func NextProtos() []string {
+	return []string{ProtocolFrame, ProtocolLegacy}
+}
+
+type legacyMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+}
+
+// rejectLegacyNode refuses a node speaking ProtocolLegacy. These nodes cannot
+// present an identity, so relaying through them would let anyone sell
+// bandwidth on behalf of any account: they must update, which they do by
+// themselves with autoupdate, and pair again.
+func rejectLegacyNode(conn *quic.Conn, stream *quic.Stream) {
+	clientID := conn.RemoteAddr().String()
+	log.Printf("Rejecting client %s, it speaks the legacy protocol", clientID)
+
+	const reason = RejectOutdated + ": nodes must authenticate, update required"
+	msg, _ := json.Marshal(legacyMessage{Type: MsgReject.String(), Data: reason})
+	stream.Write(append(msg, '\n'))
+	stream.Close()
+
+	// give the node a chance to read the reject before tearing down
+	select {
+	case <-conn.Context().Done():
+	case <-time.After(time.Second):
+	}
+	conn.CloseWithError(closeRejected, reason)
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"server/data"
+	"sync"
+	"time"
+)
+
+// Limits of user plans, as labelled in the throttling metrics
+const (
+	LimitConnections = "connections"
+	LimitRequests    = "requests"
+	LimitBandwidth   = "bandwidth"
+)
+
+// limitError refuses a connection of a user over one of the limits of its plan
+type limitError struct {
+	limit string
+}
+
+func (e *limitError) Error() string {
+	switch e.limit {
+	case LimitConnections:
+		return "too many concurrent connections"
+	case LimitRequests:
+		return "too many requests"
+	default:
+		return e.limit + " limit exceeded"
+	}
+}
+
+// tokenBucket refills rate tokens per second up to burst, a nil bucket is
+// unlimited
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// allow takes n tokens if the bucket holds them
+func (b *tokenBucket) allow(n float64) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// reserve takes n tokens, going into debt if needed, and returns how long
+// the caller has to wait for the debt to be paid back
+func (b *tokenBucket) reserve(n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) full() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	return b.tokens >= b.burst
+}
+
+// throttle holds the caller back until n more bytes fit in the bandwidth of
+// the user
+func (u *usage) throttle(n int) {
+	if u == nil {
+		return
+	}
+
+	if delay := u.bandwidth.Load().reserve(float64(n)); delay > 0 {
+		data.LogThrottled(LimitBandwidth)
+		data.LogThrottleDelay(delay)
+		time.Sleep(delay)
+	}
+}
+
+// allowDatagram tells whether a datagram of n bytes fits in the bandwidth of
+// the user, datagrams are dropped rather than delayed
+func (u *usage) allowDatagram(n int) bool {
+	if u == nil || u.bandwidth.Load().allow(float64(n)) {
+		return true
+	}
+	data.LogThrottled(LimitBandwidth)
+	return false
+}
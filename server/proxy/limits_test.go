@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllow(t *testing.T) {
+	b := newTokenBucket(1, 3)
+	for i := range 3 {
+		if !b.allow(1) {
+			t.Fatalf("request %d of the burst was refused", i+1)
+		}
+	}
+	if b.allow(1) {
+		t.Fatal("request over the burst was allowed")
+	}
+
+	b.last = b.last.Add(-time.Second)
+	if !b.allow(1) {
+		t.Fatal("bucket did not refill")
+	}
+	if b.allow(1) {
+		t.Fatal("bucket refilled more than its rate")
+	}
+
+	// idle for long, the bucket holds no more than the burst
+	b.last = b.last.Add(-time.Hour)
+	if !b.full() || b.allow(4) || !b.allow(3) {
+		t.Fatal("bucket refilled past its burst")
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	b := newTokenBucket(1000, 1000)
+	if delay := b.reserve(1000); delay != 0 {
+		t.Fatalf("reserving the burst waits %v", delay)
+	}
+
+	// the debt is paid back at the rate of the bucket
+	delay := b.reserve(500)
+	if delay < 450*time.Millisecond || delay > 500*time.Millisecond {
+		t.Fatalf("reserving 500 bytes over the burst waits %v, want about 500ms", delay)
+	}
+	if b.full() {
+		t.Fatal("bucket in debt is full")
+	}
+}
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	for _, rate := range []float64{0, -1} {
+		b := newTokenBucket(rate, 10)
+		if b != nil {
+			t.Fatalf("rate %v is limited", rate)
+		}
+		if !b.allow(1e9) || b.reserve(1e9) != 0 || !b.full() {
+			t.Fatal("nil bucket is limited")
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"server/database"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxUIDLength = 128
+
+// pairingCodeTTL is how long a node waits for its code to be entered before
+// asking for a new one
+const pairingCodeTTL = 10 * time.Minute
+
+// pairingAlphabet leaves out characters that are easily confused when read
+// from a terminal
+const pairingAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+var ErrUnknownPairingCode = errors.New("unknown or expired pairing code")
+
+// PairingCode pairs a node once its runner enters it on the dashboard, which
+// claims it for the signed in account. Headless nodes print it, the tray
+// opens the dashboard with it.
+type PairingCode struct {
+	Code      string `json:"code"`
+	ExpiresIn int    `json:"expires_in"` // seconds
+}
+
+type pendingPairing struct {
+	client  *QuicClient
+	expires time.Time
+}
+
+var (
+	pairingCodes = make(map[string]pendingPairing)
+	pairingMutex sync.Mutex
+	// pairMutex serializes pairings, which come from the node or the dashboard
+	pairMutex sync.Mutex
+)
+
+// Identity is issued to a node when it is paired with an account, the node
+// presents it in every hello from then on.
+type Identity struct {
+	NodeID string `json:"node_id"`
+	Token  string `json:"token"`
+}
+
+// pair links the node to the account uid and sends it the identity it
+// authenticates with. Only PairWithCode pairs nodes, the uid comes from the
+// dashboard backend that signed the runner in. A paired node keeps its
+// account, it is paired again under a new ID once it forgets its identity.
+func (c *QuicClient) pair(uid string) error {
+	if uid == "" || len(uid) > maxUIDLength {
+		return fmt.Errorf("invalid uid")
+	}
+
+	pairMutex.Lock()
+	defer pairMutex.Unlock()
+
+	if c.paired {
+		return fmt.Errorf("already paired")
+	}
+
+	nodeID := randomHex(16)
+	token := randomHex(32)
+
+	if err := database.RegisterNode(nodeID, uid, token); err != nil {
+		return fmt.Errorf("storing node identity: %w", err)
+	}
+
+	// the dashboard database is optional, Redis is what nodes authenticate against
+	if db, err := database.InitDatabase(os.Getenv("DATABASE_URL")); err != nil {
+		log.Printf("Cannot link node %s to its owner: %v", nodeID, err)
+	} else {
+		if err := database.AddNode(db, uid, nodeID); err != nil {
+			log.Printf("Error adding node %s to %s, %v", nodeID, uid, err)
+		}
+		db.Close()
+	}
+
+	payload, _ := json.Marshal(Identity{NodeID: nodeID, Token: token})
+	if err := c.SendMessage(Message{Type: MsgIdentity, Data: payload}); err != nil {
+		return err
+	}
+
+	log.Printf("Paired node %s with client %s for %s", nodeID, c.ID, uid)
+
+	c.identityMutex.Lock()
+	c.ID = nodeID
+	c.paired = true
+	c.identityMutex.Unlock()
+	c.register()
+	return nil
+}
+
+// issuePairingCode sends the node a new code, replacing the previous one
+func (c *QuicClient) issuePairingCode() error {
+	pairMutex.Lock()
+	paired := c.paired
+	pairMutex.Unlock()
+	if paired {
+		return fmt.Errorf("already paired")
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+	code := make([]byte, 0, 9)
+	for i, v := range b {
+		if i == 4 {
+			code = append(code, '-')
+		}
+		code = append(code, pairingAlphabet[int(v)%len(pairingAlphabet)])
+	}
+
+	now := time.Now()
+	pairingMutex.Lock()
+	for other, pending := range pairingCodes {
+		if pending.client == c || now.After(pending.expires) {
+			delete(pairingCodes, other)
+		}
+	}
+	pairingCodes[string(code)] = pendingPairing{client: c, expires: now.Add(pairingCodeTTL)}
+	pairingMutex.Unlock()
+
+	payload, _ := json.Marshal(PairingCode{Code: string(code), ExpiresIn: int(pairingCodeTTL.Seconds())})
+	return c.SendMessage(Message{Type: MsgPairCode, Data: payload})
+}
+
+// PairWithCode pairs the node that was given code with the account uid, a
+// code is only used once
+func PairWithCode(code, uid string) error {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) == 8 {
+		code = code[:4] + "-" + code[4:]
+	}
+
+	pairingMutex.Lock()
+	pending, ok := pairingCodes[code]
+	delete(pairingCodes, code)
+	pairingMutex.Unlock()
+
+	if !ok || time.Now().After(pending.expires) || pending.client.conn.Context().Err() != nil {
+		return ErrUnknownPairingCode
+	}
+	return pending.client.pair(uid)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
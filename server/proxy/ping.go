@@ -32,15 +32,15 @@ func ReportPing() {
 			}
 
 			client.lastPing = time.Now()
-			if client.lastPingID != "" {
+			if client.lastPingID != 0 {
 				client.Kick("ping timeout")
 				continue
 			}
 
-			pingID := string(rune(rand.Int()))
+			pingID := rand.Uint32() | 1 // never 0, which means no ping in flight
 
 			err := client.SendMessage(Message{
-				Type: "ping",
+				Type: MsgPing,
 				ID:   pingID,
 			})
 			if err != nil {
@@ -52,7 +52,7 @@ func ReportPing() {
 	}
 }
 func (c *QuicClient) Pong() {
-	c.lastPingID = ""
+	c.lastPingID = 0
 	c.Metrics.Latency = float64(int16(time.Since(c.lastPing).Milliseconds()))
 	c.UpdateScore()
 }
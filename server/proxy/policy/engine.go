@@ -0,0 +1,76 @@
+package policy
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Engine serves the policy of a file and reloads it when the file changes.
+// DefaultConfig applies until the file is first loaded, a file that is
+// removed or fails to load keeps the current policy in place.
+type Engine struct {
+	path    string
+	policy  atomic.Pointer[Policy]
+	modTime time.Time
+	size    int64
+	missing bool
+}
+
+func NewEngine(path string) *Engine {
+	e := &Engine{path: path}
+
+	policy, err := Compile(DefaultConfig)
+	if err != nil {
+		panic(err) // DefaultConfig is valid
+	}
+	e.policy.Store(policy)
+
+	e.reload()
+	return e
+}
+
+func (e *Engine) Check(account, host string, port int) error {
+	return e.policy.Load().Check(account, host, port)
+}
+
+// Watch checks the file for changes every interval
+func (e *Engine) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		e.reload()
+	}
+}
+
+func (e *Engine) reload() {
+	info, err := os.Stat(e.path)
+	if errors.Is(err, os.ErrNotExist) {
+		if !e.missing {
+			log.Printf("No policy file at %s, keeping the current policy", e.path)
+			e.missing = true
+			e.modTime, e.size = time.Time{}, 0
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("Policy file %s unavailable: %v", e.path, err)
+		return
+	}
+	e.missing = false
+	if info.ModTime().Equal(e.modTime) && info.Size() == e.size {
+		return
+	}
+	e.modTime, e.size = info.ModTime(), info.Size()
+
+	policy, err := LoadFile(e.path)
+	if err != nil {
+		log.Printf("Failed to load policy, keeping the current one: %v", err)
+		return
+	}
+	e.policy.Store(policy)
+	log.Printf("Loaded policy from %s", e.path)
+}
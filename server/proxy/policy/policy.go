@@ -0,0 +1,261 @@
+// Package policy decides which destinations users may reach through the
+// nodes, protecting the networks and the reputation of node runners.
+//
+// The policy is read from a JSON file:
+//
+//	{
+//	  "allow_ports": ["80", "443", "1024-65535"],
+//	  "deny_ports": ["25", "465", "587"],
+//	  "deny_cidrs": ["10.0.0.0/8", "fd00::/8"],
+//	  "deny_domains": ["localhost", "*.internal"],
+//	  "categories": {"gambling": ["casino.example"]},
+//	  "deny_categories": ["gambling"],
+//	  "users": {
+//	    "<account id>": {
+//	      "allow_ports": ["25"],
+//	      "allow_domains": ["mail.example"],
+//	      "allow_categories": ["gambling"],
+//	      "deny_domains": ["example.org"]
+//	    }
+//	  }
+//	}
+//
+// An empty allow_ports allows every port that is not denied. A domain
+// matches itself and its subdomains, "*.example.com" only its subdomains.
+// Overrides of a user are applied on top of the global lists: what they
+// allow bypasses the global denies.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Config struct {
+	AllowPorts     []string            `json:"allow_ports"`
+	DenyPorts      []string            `json:"deny_ports"`
+	DenyCIDRs      []string            `json:"deny_cidrs"`
+	DenyDomains    []string            `json:"deny_domains"`
+	Categories     map[string][]string `json:"categories"`
+	DenyCategories []string            `json:"deny_categories"`
+	Users          map[string]Override `json:"users"`
+}
+
+type Override struct {
+	AllowPorts      []string `json:"allow_ports"`
+	AllowDomains    []string `json:"allow_domains"`
+	AllowCategories []string `json:"allow_categories"`
+	DenyDomains     []string `json:"deny_domains"`
+}
+
+// DefaultConfig is used when no policy file exists, it keeps users off mail
+// submission ports and the local networks of the nodes
+var DefaultConfig = Config{
+	DenyPorts: []string{"25", "465", "587"},
+	DenyCIDRs: []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/4", "240.0.0.0/4",
+		"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+	},
+	DenyDomains: []string{"localhost", "*.local", "*.internal", "*.lan"},
+}
+
+// Denial is returned for destinations refused by the policy
+type Denial struct {
+	Reason string
+}
+
+func (d *Denial) Error() string {
+	return "blocked by policy: " + d.Reason
+}
+
+// Policy is a compiled Config
+type Policy struct {
+	allowPorts     portSet
+	denyPorts      portSet
+	denyCIDRs      []netip.Prefix
+	denyDomains    domainSet
+	categories     map[string]domainSet
+	denyCategories []string
+	users          map[string]*override
+}
+
+type override struct {
+	allowPorts      portSet
+	allowDomains    domainSet
+	allowCategories map[string]bool
+	denyDomains     domainSet
+}
+
+func LoadFile(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return Compile(config)
+}
+
+func Compile(config Config) (*Policy, error) {
+	p := &Policy{
+		denyDomains:    newDomainSet(config.DenyDomains),
+		categories:     make(map[string]domainSet),
+		denyCategories: config.DenyCategories,
+		users:          make(map[string]*override),
+	}
+
+	var err error
+	if p.allowPorts, err = parsePorts(config.AllowPorts); err != nil {
+		return nil, err
+	}
+	if p.denyPorts, err = parsePorts(config.DenyPorts); err != nil {
+		return nil, err
+	}
+	for _, cidr := range config.DenyCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny_cidrs entry %q: %w", cidr, err)
+		}
+		p.denyCIDRs = append(p.denyCIDRs, prefix.Masked())
+	}
+	for category, domains := range config.Categories {
+		p.categories[category] = newDomainSet(domains)
+	}
+	for _, category := range config.DenyCategories {
+		if _, ok := p.categories[category]; !ok {
+			return nil, fmt.Errorf("unknown category %q in deny_categories", category)
+		}
+	}
+
+	for account, user := range config.Users {
+		o := &override{
+			allowDomains:    newDomainSet(user.AllowDomains),
+			allowCategories: make(map[string]bool),
+			denyDomains:     newDomainSet(user.DenyDomains),
+		}
+		if o.allowPorts, err = parsePorts(user.AllowPorts); err != nil {
+			return nil, fmt.Errorf("user %s: %w", account, err)
+		}
+		for _, category := range user.AllowCategories {
+			o.allowCategories[category] = true
+		}
+		p.users[account] = o
+	}
+
+	return p, nil
+}
+
+// Check tells whether account may connect to host:port, host being an IP
+// address or a domain name. A refusal is a *Denial.
+func (p *Policy) Check(account, host string, port int) error {
+	o := p.users[account]
+	if o == nil {
+		o = &override{}
+	}
+
+	if !o.allowPorts.contains(port) {
+		if p.denyPorts.contains(port) || len(p.allowPorts) > 0 && !p.allowPorts.contains(port) {
+			return &Denial{Reason: "port " + strconv.Itoa(port) + " is not allowed"}
+		}
+	}
+
+	if addr, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		addr = addr.Unmap()
+		for _, prefix := range p.denyCIDRs {
+			if prefix.Contains(addr) {
+				return &Denial{Reason: "address " + addr.String() + " is not allowed"}
+			}
+		}
+		return nil
+	}
+
+	domain := strings.TrimSuffix(strings.ToLower(host), ".")
+	if o.denyDomains.match(domain) {
+		return &Denial{Reason: "domain " + domain + " is not allowed"}
+	}
+	if o.allowDomains.match(domain) {
+		return nil
+	}
+	if p.denyDomains.match(domain) {
+		return &Denial{Reason: "domain " + domain + " is not allowed"}
+	}
+	for _, category := range p.denyCategories {
+		if !o.allowCategories[category] && p.categories[category].match(domain) {
+			return &Denial{Reason: "domain " + domain + " is in the " + category + " category"}
+		}
+	}
+	return nil
+}
+
+// portSet holds inclusive port ranges
+type portSet [][2]int
+
+func parsePorts(entries []string) (portSet, error) {
+	var set portSet
+	for _, entry := range entries {
+		low, high, isRange := strings.Cut(entry, "-")
+		if !isRange {
+			high = low
+		}
+
+		from, err1 := strconv.Atoi(strings.TrimSpace(low))
+		to, err2 := strconv.Atoi(strings.TrimSpace(high))
+		if err1 != nil || err2 != nil || from < 1 || to > 65535 || from > to {
+			return nil, fmt.Errorf("invalid port range %q", entry)
+		}
+		set = append(set, [2]int{from, to})
+	}
+	return set, nil
+}
+
+func (s portSet) contains(port int) bool {
+	for _, r := range s {
+		if port >= r[0] && port <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// domainSet maps a domain to whether its entry only covers subdomains
+type domainSet map[string]bool
+
+func newDomainSet(entries []string) domainSet {
+	set := make(domainSet, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+		if domain, ok := strings.CutPrefix(entry, "*."); ok {
+			if _, exists := set[domain]; !exists {
+				set[domain] = true
+			}
+			continue
+		}
+		set[entry] = false
+	}
+	return set
+}
+
+func (s domainSet) match(domain string) bool {
+	if len(s) == 0 {
+		return false
+	}
+
+	if subdomainsOnly, ok := s[domain]; ok && !subdomainsOnly {
+		return true
+	}
+	for i := strings.IndexByte(domain, '.'); i >= 0; i = strings.IndexByte(domain, '.') {
+		domain = domain[i+1:]
+		if _, ok := s[domain]; ok {
+			return true
+		}
+	}
+	return false
+}
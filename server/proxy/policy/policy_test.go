@@ -0,0 +1,131 @@
+package policy
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		entries []string
+		want    portSet
+	}{
+		{nil, nil},
+		{[]string{"443"}, portSet{{443, 443}}},
+		{[]string{"80", " 1024 - 65535 "}, portSet{{80, 80}, {1024, 65535}}},
+		{[]string{"1-1"}, portSet{{1, 1}}},
+	}
+	for _, tt := range tests {
+		got, err := parsePorts(tt.entries)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("parsePorts(%q) = %v, %v, want %v", tt.entries, got, err, tt.want)
+		}
+	}
+
+	for _, entry := range []string{"", "0", "65536", "http", "100-10", "1-", "-1", "1-2-3"} {
+		if set, err := parsePorts([]string{entry}); err == nil {
+			t.Errorf("parsePorts(%q) = %v, want an error", entry, set)
+		}
+	}
+}
+
+func TestDomainSetMatch(t *testing.T) {
+	set := newDomainSet([]string{"Example.com.", "*.internal", " casino.example "})
+
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"example.com", true},
+		{"www.example.com", true},
+		{"a.b.example.com", true},
+		{"notexample.com", false},
+		{"example.com.evil", false},
+		{"internal", false},
+		{"db.internal", true},
+		{"casino.example", true},
+		{"example", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := set.match(tt.domain); got != tt.want {
+			t.Errorf("match(%q) = %v, want %v", tt.domain, got, tt.want)
+		}
+	}
+
+	// an exact entry also covers what a wildcard of the same domain does
+	both := newDomainSet([]string{"example.org", "*.example.org"})
+	if !both.match("example.org") || !both.match("www.example.org") {
+		t.Error("exact entry lost to the wildcard of the same domain")
+	}
+	if newDomainSet(nil).match("example.com") {
+		t.Error("empty set matched")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	config := DefaultConfig
+	config.AllowPorts = []string{"25", "80", "443", "1024-65535"}
+	config.Categories = map[string][]string{"gambling": {"casino.example"}}
+	config.DenyCategories = []string{"gambling"}
+	config.Users = map[string]Override{
+		"mailer":  {AllowPorts: []string{"25"}},
+		"gambler": {AllowCategories: []string{"gambling"}, DenyDomains: []string{"example.org"}},
+		"intra":   {AllowDomains: []string{"wiki.internal"}},
+	}
+	p, err := Compile(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		account string
+		host    string
+		port    int
+		allowed bool
+	}{
+		{"", "example.com", 443, true},
+		{"", "example.com", 22, false},
+		{"", "example.com", 8080, true},
+		{"", "mail.example.com", 25, false}, // denied even though allowed globally
+		{"mailer", "mail.example.com", 25, true},
+		{"mailer", "mail.example.com", 465, false},
+		{"", "93.184.216.34", 80, true},
+		{"", "127.0.0.1", 80, false},
+		{"", "[::1]", 80, false},
+		{"", "::ffff:192.168.1.1", 80, false},
+		{"", "localhost", 80, false},
+		{"", "LOCALHOST.", 80, false},
+		{"", "printer.local", 80, false},
+		{"", "www.casino.example", 443, false},
+		{"gambler", "www.casino.example", 443, true},
+		{"gambler", "example.org", 443, false},
+		{"", "example.org", 443, true},
+		{"intra", "wiki.internal", 443, true},
+		{"intra", "db.internal", 443, false},
+		{"unknown", "example.com", 443, true},
+	}
+	for _, tt := range tests {
+		err := p.Check(tt.account, tt.host, tt.port)
+		var denial *Denial
+		if tt.allowed && err != nil || !tt.allowed && !errors.As(err, &denial) {
+			t.Errorf("Check(%q, %q, %d) = %v, want allowed %v", tt.account, tt.host, tt.port, err, tt.allowed)
+		}
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	tests := []Config{
+		{AllowPorts: []string{"0"}},
+		{DenyPorts: []string{"http"}},
+		{DenyCIDRs: []string{"10.0.0.0/33"}},
+		{DenyCategories: []string{"unknown"}},
+		{Users: map[string]Override{"a": {AllowPorts: []string{"70000"}}}},
+	}
+	for _, config := range tests {
+		if _, err := Compile(config); err == nil {
+			t.Errorf("Compile(%+v) accepted an invalid policy", config)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ProtocolFrame is the ALPN identifier of the length-prefixed binary frame
+// protocol, see ProtocolLegacy for the nodes that predate it.
+const ProtocolFrame = "turbo-proxy/2"
+
+type MessageType uint8
+
+const (
+	MsgConnect MessageType = iota + 1
+	MsgData
+	MsgClose
+	MsgPing
+	MsgPong
+	MsgAddress
+	MsgUIDRegister
+	MsgStacktrace
+	MsgDummy
+	MsgHello
+	MsgReject
+	MsgIdentity
+	MsgDatagram
+	MsgConnected
+	MsgConnectError
+	MsgAvailability
+	MsgPairCode
+)
+
+var messageTypeNames = map[MessageType]string{
+	MsgConnect:      "connect",
+	MsgData:         "data",
+	MsgClose:        "close",
+	MsgPing:         "ping",
+	MsgPong:         "pong",
+	MsgAddress:      "address",
+	MsgUIDRegister:  "uid-register",
+	MsgStacktrace:   "stacktrace",
+	MsgDummy:        "dummy",
+	MsgHello:        "hello",
+	MsgReject:       "reject",
+	MsgIdentity:     "identity",
+	MsgDatagram:     "datagram",
+	MsgConnected:    "connected",
+	MsgConnectError: "connect_error",
+	MsgAvailability: "availability",
+	MsgPairCode:     "pair_code",
+}
+
+// hasAddr reports whether the payload starts with an address
+func (t MessageType) hasAddr() bool {
+	return t == MsgConnect || t == MsgDatagram
+}
+
+func (t MessageType) String() string {
+	if name, ok := messageTypeNames[t]; ok {
+		return name
+	}
+	return "unknown(" + strconv.Itoa(int(t)) + ")"
+}
+
+type Message struct {
+	Type MessageType
+	ID   uint32
+	// Addr also contains port of the target website
+	Addr string
+	Data []byte
+}
+
+const (
+	frameHeaderSize = 9
+	maxFramePayload = 1 << 20
+)
+
+var errMalformedMessage = errors.New("malformed message")
+
+func newCodec(rw io.ReadWriter) *frameCodec {
+	return &frameCodec{r: bufio.NewReader(rw), w: rw}
+}
+
+// frameCodec reads and writes messages on the control stream of a node.
+// WriteMessage is not safe for concurrent use, callers hold QuicClient.mutex.
+//
+//	+------+---------------+-------------+---------+
+//	| type | connection ID | payload len | payload |
+//	|  1B  |    4B (BE)    |   4B (BE)   |   ...   |
+//	+------+---------------+-------------+---------+
+//
+// The payload of a connect frame is the 2-byte target address length,
+// the address itself and the first bytes sent by the user. The node answers
+// on the same stream with a connected frame before relaying, or with a
+// connect_error frame holding the reason of the failure. Datagram frames
+// carry the address the same way, the ID is then a UDP association and the
+// frame is sent as a QUIC datagram whenever it fits in one. Availability
+// frames hold the JSON Availability of the node. An unpaired node sends an
+// empty pair_code frame to get a PairingCode, sent back in a pair_code frame.
+// The uid-register frame of earlier nodes is ignored, anyone could claim
+// any account with it.
+type frameCodec struct {
+	r   *bufio.Reader
+	w   io.Writer
+	buf []byte
+}
+
+func (c *frameCodec) ReadMessage() (Message, error) {
+	return readFrame(c.r)
+}
+
+func (c *frameCodec) WriteMessage(msg Message) error {
+	buf, err := appendFrame(c.buf[:0], msg)
+	if err != nil {
+		return err
+	}
+	c.buf = buf
+
+	_, err = c.w.Write(buf)
+	return err
+}
+
+// readFrame never reads past the end of the frame, so the rest of r can be
+// handed over to a relay once the frame is decoded.
+func readFrame(r io.Reader) (Message, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{
+		Type: MessageType(header[0]),
+		ID:   binary.BigEndian.Uint32(header[1:5]),
+	}
+
+	size := binary.BigEndian.Uint32(header[5:9])
+	if size > maxFramePayload {
+		return Message{}, fmt.Errorf("frame payload of %d bytes exceeds limit", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Message{}, err
+	}
+
+	if !msg.Type.hasAddr() {
+		msg.Data = payload
+		return msg, nil
+	}
+
+	if len(payload) < 2 {
+		return msg, errMalformedMessage
+	}
+	addrLen := int(binary.BigEndian.Uint16(payload))
+	if len(payload) < 2+addrLen {
+		return msg, errMalformedMessage
+	}
+	msg.Addr = string(payload[2 : 2+addrLen])
+	msg.Data = payload[2+addrLen:]
+
+	return msg, nil
+}
+
+func appendFrame(buf []byte, msg Message) ([]byte, error) {
+	size := len(msg.Data)
+	if msg.Type.hasAddr() {
+		size += 2 + len(msg.Addr)
+	}
+	if size > maxFramePayload {
+		return buf, fmt.Errorf("frame payload of %d bytes exceeds limit", size)
+	}
+
+	buf = append(buf, byte(msg.Type))
+	buf = binary.BigEndian.AppendUint32(buf, msg.ID)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(size))
+	if msg.Type.hasAddr() {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(msg.Addr)))
+		buf = append(buf, msg.Addr...)
+	}
+	return append(buf, msg.Data...), nil
+}
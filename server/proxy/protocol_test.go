@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"ping", Message{Type: MsgPing, ID: 7}},
+		{"data", Message{Type: MsgAvailability, ID: 1, Data: []byte(`{"available":true}`)}},
+		{"connect", Message{Type: MsgConnect, ID: 42, Addr: "example.com:443"}},
+		{"connect with early data", Message{Type: MsgConnect, ID: 1 << 31, Addr: "[::1]:80", Data: []byte("GET / HTTP/1.1\r\n")}},
+		{"datagram", Message{Type: MsgDatagram, ID: 3, Addr: "1.1.1.1:53", Data: []byte{0, 1, 2}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := appendFrame(nil, tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// what follows the frame is left for the relay
+			r := bytes.NewReader(append(frame, "rest"...))
+			got, err := readFrame(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Type != tt.msg.Type || got.ID != tt.msg.ID || got.Addr != tt.msg.Addr || !bytes.Equal(got.Data, tt.msg.Data) {
+				t.Fatalf("read %+v, wrote %+v", got, tt.msg)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "rest" {
+				t.Fatalf("readFrame read past the frame, %q left", rest)
+			}
+		})
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	frame, err := appendFrame(nil, Message{Type: MsgConnect, ID: 1, Addr: "example.com:443", Data: []byte("hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{0, 1, frameHeaderSize - 1, frameHeaderSize, frameHeaderSize + 1, len(frame) - 1} {
+		if _, err := readFrame(bytes.NewReader(frame[:n])); err == nil {
+			t.Errorf("frame cut to %d of %d bytes was accepted", n, len(frame))
+		}
+	}
+}
+
+func TestReadFrameMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{"connect without address length", []byte{byte(MsgConnect), 0, 0, 0, 1, 0, 0, 0, 1, 0}},
+		{"address longer than payload", []byte{byte(MsgConnect), 0, 0, 0, 1, 0, 0, 0, 3, 0, 9, 'a'}},
+		{"datagram without address length", []byte{byte(MsgDatagram), 0, 0, 0, 1, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readFrame(bytes.NewReader(tt.frame)); !errors.Is(err, errMalformedMessage) {
+				t.Fatalf("expected errMalformedMessage, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFramePayloadLimit(t *testing.T) {
+	if _, err := appendFrame(nil, Message{Type: MsgStacktrace, Data: make([]byte, maxFramePayload+1)}); err == nil {
+		t.Error("appendFrame accepted a payload over the limit")
+	}
+	if _, err := appendFrame(nil, Message{Type: MsgConnect, Addr: strings.Repeat("a", 10), Data: make([]byte, maxFramePayload-11)}); err == nil {
+		t.Error("appendFrame did not count the address towards the limit")
+	}
+
+	// a header announcing more than the limit is refused before allocating
+	header := []byte{byte(MsgStacktrace), 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}
+	if _, err := readFrame(bytes.NewReader(header)); err == nil {
+		t.Error("readFrame accepted a payload over the limit")
+	}
+}
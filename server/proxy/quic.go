@@ -3,16 +3,9 @@ package proxy
 import (
 	"context"
 	"crypto/tls"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net"
-	"net/http"
-	"os"
-	"server/database"
-	"server/proxy/user"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,14 +13,6 @@ import (
 	"github.com/quic-go/quic-go"
 )
 
-type Message struct {
-	Type string `json:"type"`
-	ID   string `json:"ID"`
-	// Addr also contains port of the target website
-	Addr string `json:"addr,omitempty"`
-	Data string `json:"data,omitempty"`
-}
-
 var (
 	QuicClients           = make(map[string]*QuicClient)
 	QuicMutex             sync.RWMutex
@@ -35,24 +20,50 @@ var (
 	BrowserScreenshotData = make(chan []byte)
 )
 
-// QuicClient represents a connected QUIC client
+// Every user connection has its own stream, whose credit is only returned
+// to the node (in MAX_STREAM_DATA window updates) once the user has read the
+// data. A user that stops reading holds at most MaxStreamReceiveWindow of the
+// connection window, which is sized for many such users before the node's
+// other connections are affected.
+var quicConfig = &quic.Config{
+	InitialStreamReceiveWindow:     256 << 10,
+	MaxStreamReceiveWindow:         1 << 20,
+	InitialConnectionReceiveWindow: 64 << 20,
+	MaxConnectionReceiveWindow:     64 << 20,
+	EnableDatagrams:                true,
+}
+
+// QuicClient represents a connected QUIC client, ID is the node ID once
+// the node is paired and its remote address until then.
 type QuicClient struct {
 	ID         string
 	conn       *quic.Conn
 	stream     *quic.Stream
+	codec      *frameCodec
+	paired     bool // authenticated, only paired nodes are registered and relay traffic
+	session    string
 	mutex      sync.Mutex
-	userConns  map[string]*Connection
+	userConns  map[uint32]*Connection
+	udpAssocs  map[uint32]*udpAssociation
 	userMutex  sync.Mutex
 	lastPing   time.Time
-	lastPingID string
+	lastPingID uint32
+	Info       *NodeInfo
 	Metrics    *Metrics
 	Stats      *ClientStats
 	kicked     atomic.Bool
+	// unavailable holds the reason a node announced for not sharing, nil
+	// while it shares
+	unavailable atomic.Pointer[string]
+
+	// identityMutex guards ID and paired while the node is unpaired, the
+	// dashboard pairs it from the goroutine of its request
+	identityMutex sync.RWMutex
 }
 
 // StartQuicServer initializes the QUIC server
 func StartQuicServer(addr string, tlsConfig *tls.Config) error {
-	listener, err := quic.ListenAddr(addr, tlsConfig, nil)
+	listener, err := quic.ListenAddr(addr, tlsConfig, quicConfig)
 	if err != nil {
 		return fmt.Errorf("failed to start QUIC server: %w", err)
 	}
@@ -64,6 +75,10 @@ func StartQuicServer(addr string, tlsConfig *tls.Config) error {
 
 	go ReportPing()
 
+	go sweepStickySessions()
+
+	go flushUsage()
+
 	return nil
 }
 
@@ -91,11 +106,18 @@ func handleQuicConnection(conn *quic.Conn) {
 		return
 	}
 
+	if conn.ConnectionState().TLS.NegotiatedProtocol == ProtocolLegacy {
+		rejectLegacyNode(conn, stream)
+		return
+	}
+
 	client := &QuicClient{
 		ID:        clientID,
 		conn:      conn,
 		stream:    stream,
-		userConns: make(map[string]*Connection),
+		codec:     newCodec(stream),
+		userConns: make(map[uint32]*Connection),
+		udpAssocs: make(map[uint32]*udpAssociation),
 		lastPing:  time.Now(),
 		Metrics: &Metrics{
 			Reliability: 0.7,
@@ -107,95 +129,96 @@ func handleQuicConnection(conn *quic.Conn) {
 		},
 	}
 
-	QuicMutex.Lock()
-	QuicClients[clientID] = client
-	QuicMutex.Unlock()
+	if err := client.handshake(); err != nil {
+		log.Printf("Handshake failed for client %s: %v", clientID, err)
+		conn.CloseWithError(closeRejected, "handshake failed")
+		return
+	}
 
 	go quicReader(client)
+	go client.receiveDatagrams()
 
-	country := "global"
-	if ip, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
-		resp, err := http.Get("http://ip-api.com/csv/" + ip + "?fields=countryCode")
-		if err == nil {
-			defer resp.Body.Close()
-			if resp.StatusCode == 200 {
-				body, err := io.ReadAll(resp.Body)
-				if err == nil {
-					if user.IsValidCountryCode(country) {
-						country = string(body)
-					}
-				}
-			}
-		}
+	client.Stats.setLocation(geolocate(conn.RemoteAddr().String()))
+
+	if _, paired := client.identity(); paired {
+		client.register()
+	} else {
+		log.Printf("Client %s is not paired, waiting for a pairing code to be entered", clientID)
+	}
+}
+
+// register makes a paired node available to users, replacing a previous
+// connection of the same node.
+func (c *QuicClient) register() {
+	QuicMutex.Lock()
+	previous := QuicClients[c.ID]
+	QuicClients[c.ID] = c
+	QuicMutex.Unlock()
+
+	if previous != nil {
+		previous.Kick("replaced by a new connection")
 	}
-	client.Stats.CountryCode = country
 
 	updatePools()
 }
 
+// unregister is a no-op if the node already reconnected
+func (c *QuicClient) unregister() int {
+	id := c.nodeID()
+	QuicMutex.Lock()
+	defer QuicMutex.Unlock()
+
+	if QuicClients[id] == c {
+		delete(QuicClients, id)
+	}
+	return len(QuicClients)
+}
+
 func quicReader(client *QuicClient) {
 	defer func() {
-		QuicMutex.Lock()
-		delete(QuicClients, client.ID)
-		log.Printf("QUIC client disconnected: %s. Remaining clients: %d", client.ID, len(QuicClients))
-		QuicMutex.Unlock()
+		remaining := client.unregister()
+		log.Printf("QUIC client disconnected: %s. Remaining clients: %d", client.nodeID(), remaining)
 
 		client.stream.Close()
 		client.conn.CloseWithError(0, "client disconnected")
+		client.closeAllConnections()
+		client.park()
 	}()
 
-	decoder := json.NewDecoder(client.stream)
 	for {
-		var msg Message
-		if err := decoder.Decode(&msg); err != nil {
+		msg, err := client.codec.ReadMessage()
+		if errors.Is(err, errMalformedMessage) {
+			log.Println("WARN: Suspicious data received from client", client.nodeID())
+			continue
+		}
+		if err != nil {
 			if client.kicked.Load() {
 				return
 			}
-			log.Printf("QUIC read error for client %s: %v", client.ID, err)
+			log.Printf("QUIC read error for client %s: %v", client.nodeID(), err)
 			return
 		}
 
 		switch msg.Type {
-		case "data":
-			client.userMutex.Lock()
-			if sc, ok := client.userConns[msg.ID]; ok {
-				if data, err := base64.StdEncoding.DecodeString(msg.Data); err == nil {
-					sc.DataChan <- data
-				} else {
-					log.Println("WARN: Suspicious data received from client", client.ID)
-				}
-			}
-			client.userMutex.Unlock()
-		case "close":
-			client.userMutex.Lock()
-			if sc, ok := client.userConns[msg.ID]; ok {
-				sc.Conn.Close()
-				delete(client.userConns, msg.ID)
-			}
-			client.userMutex.Unlock()
-		case "address":
-			client.Stats.CryptoAddr = msg.ID
-		case "pong":
+		case MsgAddress:
+			client.Stats.CryptoAddr = string(msg.Data)
+		case MsgDatagram:
+			client.deliverDatagram(msg)
+		case MsgPong:
 			client.Pong()
-		case "uid-register":
-			db, err := database.InitDatabase(os.Getenv("DATABASE_URL"))
-			if err != nil {
-				log.Println(err)
-			}
-
-			err = database.AddNode(db, msg.ID, client.ID)
-			if err != nil {
-				log.Printf("Error adding node to %s, %v", client.ID, err)
-			} else {
-				log.Printf("Registered Node %s for client %s", msg.ID, client.ID)
+		case MsgAvailability:
+			client.setAvailability(msg.Data)
+		case MsgPairCode:
+			if err := client.issuePairingCode(); err != nil {
+				log.Printf("Cannot issue pairing code to client %s: %v", client.nodeID(), err)
 			}
-
-			db.Close()
+		case MsgUIDRegister:
+			// anyone can claim any uid, nodes pair with a code entered by the signed in runner
+			log.Printf("Ignoring uid-register of client %s, nodes pair with a code", client.nodeID())
 
 			/*
 				TODO(architecture):
-					- Put Quic client stats into database
-					- Link Distant node with Quic client so that,
+					- Put Quic client stats into database so that
 					- Server can update Node stats.
 			*/
 		}
@@ -210,14 +233,21 @@ func (c *QuicClient) SendMessage(msg Message) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return err
-	}
-	data = append(data, '\n') // Add newline for JSON decoder
+	return c.codec.WriteMessage(msg)
+}
+
+// identity returns ID and paired, which change when the node is paired
+func (c *QuicClient) identity() (id string, paired bool) {
+	c.identityMutex.RLock()
+	defer c.identityMutex.RUnlock()
+	return c.ID, c.paired
+}
 
-	_, err = c.stream.Write(data)
-	return err
+// nodeID returns ID to code that may run before the node is paired,
+// registered nodes keep their ID and it is read directly
+func (c *QuicClient) nodeID() string {
+	id, _ := c.identity()
+	return id
 }
 
 func (c *QuicClient) Kick(reason string) {
@@ -228,20 +258,14 @@ func (c *QuicClient) Kick(reason string) {
 	c.conn.CloseWithError(0, reason)
 
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
 	c.stream.Close()
+	c.mutex.Unlock()
 
-	for id, sc := range c.userConns {
-		sc.Conn.Close()
-		delete(c.userConns, id)
-	}
+	c.closeAllConnections()
 
-	QuicMutex.Lock()
-	delete(QuicClients, c.ID)
-	QuicMutex.Unlock()
+	c.unregister()
 
 	updatePools() // TODO: Inefficient, optimize client erasure
 
-	log.Printf("Kicked QUIC client %s for \"%s\"", c.ID, reason)
+	log.Printf("Kicked QUIC client %s for \"%s\"", c.nodeID(), reason)
 }
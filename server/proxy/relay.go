@@ -1,18 +1,24 @@
 package proxy
 
 import (
-	"encoding/base64"
-	"fmt"
+	"errors"
+	"io"
 	"log"
 	"net"
 	data2 "server/data"
+	"server/proxy/policy"
 	"server/proxy/socks"
+	"strconv"
 	"sync/atomic"
 	"time"
 )
 
 var (
 	connectTimeout = 5 * time.Second
+	// a user or node that does not drain a write within stallTimeout is
+	// dropped, releasing the flow control credit the unread data holds on
+	// the node connection
+	stallTimeout = 30 * time.Second
 )
 
 type ClientStats struct {
@@ -22,145 +28,169 @@ type ClientStats struct {
 	BytesReceived uint64
 	CryptoAddr    string
 	CountryCode   string
+	Region        string // as geolocated, see user.NormalizeLocation for matching
+	City          string
+	ASN           string // number without the "AS" prefix
+	ISP           string
 }
 
 func HandleSocksConn(conn net.Conn) {
 	defer conn.Close()
 
-	host, port, params, err := socks.HandleSocksHandshake(conn)
-	country := "global"
-	if _, exists := params["country"]; exists {
-		country = params["country"]
-	}
-
+	req, err := socks.HandleSocksHandshake(conn)
 	if err != nil {
 		log.Printf("SOCKS handshake failed for %s, %v", conn.RemoteAddr(), err)
 		return
 	}
 
-	var client *QuicClient
+	if req.Command == socks.UDPAssociateCommand {
+		handleUDPAssociate(conn, req)
+		return
+	}
 
 	pc := CreateConnection(conn)
+	addr := net.JoinHostPort(req.Host, strconv.Itoa(req.Port))
 
-	_, err = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}) // success
+	var detach func()
+	pc.usage, detach, err = trackUsage(req.Account, conn)
 	if err != nil {
-		log.Printf("Failed to send SOCKS success response to %s: %v", conn.RemoteAddr(), err)
+		log.Printf("SOCKS connection to %s refused: %v", addr, err)
+		req.Reply(conn, socks.NotAllowed, nil)
 		return
 	}
+	defer detach()
 
-	// Premake connect message
-	buffer := make([]byte, 32*1024)
-	var connData string
-	n, err := pc.Conn.Read(buffer)
+	client, t, err := connectWithFailover(pc, newSelector(req.Account, req.Params), addr)
 	if err != nil {
+		log.Printf("SOCKS connection to %s failed: %v", addr, err)
+		req.Reply(conn, socksStatus(err), nil)
 		return
 	}
-	if n > 0 {
-		connData = base64.StdEncoding.EncodeToString(buffer[:n])
-		pc.Features.Inbound[time.Since(pc.Features.StartTime).Microseconds()] += uint16(n)
-	}
-	msg := Message{Type: "connect", ID: pc.ID, Addr: fmt.Sprintf("%s:%d", host, port), Data: connData}
 
-	success := false
-	attempts := 0
+	if err := req.Reply(conn, socks.SuccessReply, nil); err != nil {
+		client.closeConnection(pc.ID)
+		return
+	}
 
-	for !success && attempts < 3 {
-		attempts++
-		client = FindClientByCountry(country)
-		if client == nil {
-			log.Println("No available clients found for this request")
-			return
-		}
+	relay(client, pc, t)
+}
 
-		client.userMutex.Lock()
-		client.userConns[pc.ID] = pc
-		client.userMutex.Unlock()
-		atomic.AddInt32(&client.Stats.ActiveConns, 1)
-
-		err = client.SendMessage(msg)
-		if err != nil {
-			log.Println("WriteJSON error:", err)
-			client.userMutex.Lock()
-			delete(client.userConns, pc.ID)
-			client.userMutex.Unlock()
-			atomic.AddInt32(&client.Stats.ActiveConns, -1)
-			continue
-		}
+// socksStatus maps a connectWithFailover error to a SOCKS5 reply code
+func socksStatus(err error) byte {
+	var denial *policy.Denial
+	if errors.As(err, &denial) {
+		return socks.NotAllowed
+	}
 
-		select {
-		case <-pc.DataChan:
-			success = true
-		case <-time.After(connectTimeout):
-			log.Printf("Connection timeout for client %s, retrying with another client", client.ID)
-			client.userMutex.Lock()
-			delete(client.userConns, pc.ID)
-			client.userMutex.Unlock()
-			atomic.AddInt32(&client.Stats.ActiveConns, -1)
-			continue
-		}
+	if errors.Is(err, errConnectTimeout) {
+		return socks.HostUnreachable // as if the node had reported ConnectTimeout
+	}
 
-		if success {
-			atomic.AddUint64(&client.Stats.BytesSent, uint64(n))
-			go relayFromSocksToQuic(client, pc)
-			relayFromChanToSocks(client, pc)
-			return
-		}
+	var ce *connectError
+	if !errors.As(err, &ce) {
+		return socks.GeneralFailure
 	}
 
-	conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+	switch ce.reason {
+	case ConnectRefused:
+		return socks.ConnectionRefused
+	case ConnectUnreachable:
+		return socks.NetworkUnreachable
+	case ConnectDNS, ConnectTimeout:
+		return socks.HostUnreachable
+	case ConnectDenied:
+		return socks.NotAllowed
+	default:
+		return socks.GeneralFailure
+	}
 }
 
-func relayFromSocksToQuic(client *QuicClient, pc *Connection) {
-	buf := make([]byte, 4096)
-	for {
-		n, err := pc.Conn.Read(buf)
-		if err != nil {
-			pc.Features.Outbound[time.Since(pc.Features.StartTime).Microseconds()] += uint16(n)
-			client.SendCloseMessage(pc.ID)
-			return
+// relay copies data between the user and the node. A direction that ends
+// cleanly is half-closed on the other side, the connection is closed once
+// both directions are done or as soon as one of them fails. The features
+// are logged once both copies returned, as they write the packet maps.
+func relay(client *QuicClient, pc *Connection, t tunnel) {
+	done := make(chan error, 2)
+
+	go func() {
+		_, err := io.Copy(&meter{
+			w:       t,
+			counter: &client.Stats.BytesSent,
+			usage:   pc.usage,
+			packets: pc.Features.Outbound,
+			start:   pc.Features.StartTime,
+		}, pc.Conn)
+		if err == nil {
+			err = t.CloseWrite()
 		}
-
-		dataSize := uint64(n)
-		atomic.AddUint64(&client.Stats.BytesSent, dataSize)
-		pc.Features.Outbound[time.Since(pc.Features.StartTime).Microseconds()] += uint16(n)
-
-		data := base64.StdEncoding.EncodeToString(buf[:n])
-		msg := Message{Type: "data", ID: pc.ID, Data: data}
-		if client.conn != nil {
-			client.SendMessage(msg)
+		done <- err
+	}()
+
+	go func() {
+		_, err := io.Copy(&meter{
+			w:       pc.Conn,
+			counter: &client.Stats.BytesReceived,
+			usage:   pc.usage,
+			packets: pc.Features.Inbound,
+			start:   pc.Features.StartTime,
+		}, t)
+		if err == nil {
+			err = closeWrite(pc.Conn)
+		}
+		done <- err
+	}()
+
+	for remaining := 2; remaining > 0; remaining-- {
+		if err := <-done; err != nil && remaining == 2 {
+			// unblock the other direction
+			t.Abort()
+			pc.Conn.Close()
 		}
 	}
+	client.closeConnection(pc.ID)
+	data2.LogConnection(pc.Features)
 }
 
-func relayFromChanToSocks(client *QuicClient, pc *Connection) {
-	for data := range pc.DataChan {
-		n, err := pc.Conn.Write(data)
-		atomic.AddUint64(&client.Stats.BytesReceived, uint64(n))
-		pc.Features.Inbound[time.Since(pc.Features.StartTime).Microseconds()] += uint16(n)
-		if err != nil {
-			//client.SendCloseMessage(pc.ID)
-			return
-		}
+var errNoHalfClose = errors.New("connection cannot be half-closed")
+
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
 	}
+	return errNoHalfClose
+}
+
+// meter records the traffic written in one direction of a relayed connection
+// and fails writes that stall for stallTimeout
+type meter struct {
+	w       io.Writer
+	counter *uint64
+	usage   *usage
+	packets map[int64]uint16
+	start   time.Time
 }
 
-func (c *QuicClient) SendCloseMessage(id string) {
-	msg := Message{Type: "close", ID: id}
-	if c.conn != nil {
-		c.SendMessage(msg)
+func (m *meter) Write(p []byte) (int, error) {
+	m.usage.throttle(len(p))
+	if d, ok := m.w.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		d.SetWriteDeadline(time.Now().Add(stallTimeout))
 	}
+	n, err := m.w.Write(p)
+	atomic.AddUint64(m.counter, uint64(n))
+	m.usage.charge(n)
+	m.packets[time.Since(m.start).Microseconds()] += uint16(n)
+	return n, err
+}
 
-	c.userMutex.Lock()
-	sc := c.userConns[id]
-	delete(c.userConns, id)
-	c.userMutex.Unlock()
-
-	if sc != nil {
-		data2.LogConnection(sc.Features)
-		atomic.AddInt32(&c.Stats.ActiveConns, -1)
-		sc.Conn.Close()
-	} else {
-		println("é- double closing")
+// closeConnection closes both sides of a connection, relay then returns and
+// logs its features
+func (c *QuicClient) closeConnection(id uint32) {
+	pc := c.detachConnection(id)
+	if pc == nil {
+		return
 	}
 
+	if pc.Conn != nil { // forwarded requests have no user connection of their own
+		pc.Conn.Close()
+	}
 }
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sessionGrace is how long the metrics and stats of a disconnected node are
+// kept for it to resume, NODE_GRACE_PERIOD overrides it in seconds.
+//
+// User connections are not reattached: their streams die with the QUIC
+// connection and neither side knows which bytes the other received.
+var sessionGrace = gracePeriod()
+
+// session is the state a node resumes by presenting its session token
+type session struct {
+	token    string
+	metrics  *Metrics
+	stats    *ClientStats
+	parkedAt time.Time
+	expiry   *time.Timer
+}
+
+var (
+	sessions      = make(map[string]*session) // node ID -> parked session
+	sessionsMutex sync.Mutex
+)
+
+func gracePeriod() time.Duration {
+	if value := os.Getenv("NODE_GRACE_PERIOD"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		log.Printf("Invalid NODE_GRACE_PERIOD %q, using default", value)
+	}
+	return 30 * time.Second
+}
+
+// park keeps the state of a paired node after its connection is gone,
+// unless the node already reconnected.
+func (c *QuicClient) park() {
+	id, paired := c.identity()
+	if !paired || sessionGrace == 0 {
+		return
+	}
+
+	QuicMutex.RLock()
+	_, replaced := QuicClients[id]
+	QuicMutex.RUnlock()
+	if replaced {
+		return
+	}
+
+	s := &session{
+		token:    c.session,
+		metrics:  c.Metrics,
+		stats:    c.Stats,
+		parkedAt: time.Now(),
+	}
+
+	sessionsMutex.Lock()
+	if previous := sessions[id]; previous != nil {
+		previous.expiry.Stop()
+	}
+	sessions[id] = s
+	s.expiry = time.AfterFunc(sessionGrace, func() {
+		sessionsMutex.Lock()
+		if sessions[id] == s {
+			delete(sessions, id)
+		}
+		sessionsMutex.Unlock()
+	})
+	sessionsMutex.Unlock()
+}
+
+// resume restores the state of the node if token matches its previous
+// session, which is either parked or still held by a connection the server
+// has not seen drop yet. A new session token is issued in every case.
+func (c *QuicClient) resume(token string) bool {
+	c.session = randomHex(16)
+
+	sessionsMutex.Lock()
+	s := sessions[c.ID]
+	if s != nil {
+		delete(sessions, c.ID)
+		s.expiry.Stop()
+	}
+	sessionsMutex.Unlock()
+
+	if s == nil {
+		QuicMutex.RLock()
+		if active := QuicClients[c.ID]; active != nil {
+			// the previous connection runs until register replaces it, it
+			// keeps its own state so that neither writes to the other's
+			metrics := *active.Metrics
+			s = &session{token: active.session, metrics: &metrics, stats: active.Stats.snapshot(), parkedAt: time.Now()}
+		}
+		QuicMutex.RUnlock()
+	}
+
+	if s == nil || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		return false
+	}
+
+	c.Metrics = s.metrics
+	c.Stats = s.stats
+	log.Printf("Node %s resumed its session after %s", c.ID, time.Since(s.parkedAt).Round(time.Millisecond))
+	return true
+}
+
+// snapshot copies stats still in use by a connection, the copy starts
+// without user connections of its own
+func (s *ClientStats) snapshot() *ClientStats {
+	return &ClientStats{
+		ConnectTime:   s.ConnectTime,
+		BytesSent:     atomic.LoadUint64(&s.BytesSent),
+		BytesReceived: atomic.LoadUint64(&s.BytesReceived),
+		CryptoAddr:    s.CryptoAddr,
+		CountryCode:   s.CountryCode,
+		Region:        s.Region,
+		City:          s.City,
+		ASN:           s.ASN,
+		ISP:           s.ISP,
+	}
+}
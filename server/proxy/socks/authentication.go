@@ -9,53 +9,67 @@ import (
 	"server/proxy/user"
 )
 
-func Authenticate(conn net.Conn) (bool, map[string]string, error) {
+func Authenticate(conn net.Conn) (*database.Account, map[string]string, error) {
 	// Read auth version (must be 0x01)
 	header := make([]byte, 1)
 	if _, err := io.ReadFull(conn, header); err != nil {
-		return false, nil, err
+		return nil, nil, err
 	}
 
 	if header[0] != 0x01 {
-		return false, nil, errors.New("unsupported auth version")
+		return nil, nil, errors.New("unsupported auth version")
 	}
 
 	userLenBuf := make([]byte, 1)
 	if _, err := io.ReadFull(conn, userLenBuf); err != nil {
-		return false, nil, err
+		return nil, nil, err
 	}
 	userLen := userLenBuf[0]
 
 	userBuf := make([]byte, userLen)
 	if _, err := io.ReadFull(conn, userBuf); err != nil {
-		return false, nil, err
+		return nil, nil, err
 	}
 	username := string(userBuf)
 	// username is actually the type of proxy , e.g. residential
 
 	passLenBuf := make([]byte, 1)
 	if _, err := io.ReadFull(conn, passLenBuf); err != nil {
-		return false, nil, err
+		return nil, nil, err
 	}
 	passLen := passLenBuf[0]
 
 	passBuf := make([]byte, passLen)
 	if _, err := io.ReadFull(conn, passBuf); err != nil {
-		return false, nil, err
+		return nil, nil, err
 	}
 	password := string(passBuf)
 
-	credits, err := database.GetCredits(password)
-	_ = credits
-	// TODO: create local user struct to consume credits
+	account, params, err := checkCredentials(username, password)
 
 	// Authentication response: version 0x01 + status
-	if err != nil && os.Getenv("DEBUG_MODE") != "1" { // TODO: replace debug mode by default creds in redis
+	if err != nil {
 		conn.Write([]byte{0x01, GeneralFailure})
-		return false, nil, err
+		return nil, nil, err
 	}
 
 	conn.Write([]byte{0x01, SuccessReply})
 
-	return true, user.ParseParams(username), nil
+	return account, params, nil
+}
+
+// checkCredentials returns the account of password and the params carried by username
+func checkCredentials(username, password string) (*database.Account, map[string]string, error) {
+	account, err := database.GetAccount(password)
+	if err != nil {
+		if os.Getenv("DEBUG_MODE") != "1" { // TODO: replace debug mode by default creds in redis
+			return nil, nil, err
+		}
+		account = database.DebugAccount(password)
+	}
+	if account.Metered() && account.Credits <= 0 {
+		return nil, nil, database.ErrNoCredits
+	}
+
+	return account, user.ParseParams(username), nil
 }
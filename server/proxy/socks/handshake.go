@@ -6,37 +6,60 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"server/database"
 )
 
 const (
-	SocksVersion = 5
+	SocksVersion  = 5
+	Socks4Version = 4
 
 	NoAuth       = 0x00
 	UserPassAuth = 0x02
 
-	ConnectCommand = 0x01
+	ConnectCommand      = 0x01
+	UDPAssociateCommand = 0x03
 
 	IPv4Address = 0x01
 	FQDN        = 0x03
 	IPv6Address = 0x04
 
-	SuccessReply   = 0x00
-	GeneralFailure = 0x01
+	SuccessReply        = 0x00
+	GeneralFailure      = 0x01
+	NotAllowed          = 0x02
+	NetworkUnreachable  = 0x03
+	HostUnreachable     = 0x04
+	ConnectionRefused   = 0x05
+	CommandNotSupported = 0x07
 )
 
-func HandleSocksHandshake(conn net.Conn) (string, int, map[string]string, error) {
+// Request is what the user asks for once authenticated. For UDP ASSOCIATE,
+// Host and Port are the address the user expects to send datagrams from.
+type Request struct {
+	Version byte
+	Command byte
+	Host    string
+	Port    int
+	Account *database.Account
+	Params  map[string]string
+}
+
+func HandleSocksHandshake(conn net.Conn) (*Request, error) {
 	header := make([]byte, 2)
 	if _, err := io.ReadFull(conn, header); err != nil {
-		return "", 0, nil, err
+		return nil, err
+	}
+
+	if header[0] == Socks4Version {
+		return handleSocks4Request(conn, header[1])
 	}
 
 	if header[0] != SocksVersion {
-		return "", 0, nil, errors.New(fmt.Sprintf("unsupported SOCKS version %d", header[0]))
+		return nil, errors.New(fmt.Sprintf("unsupported SOCKS version %d", header[0]))
 	}
 
 	authMethods := make([]byte, header[1])
 	if _, err := io.ReadFull(conn, authMethods); err != nil {
-		return "", 0, nil, err
+		return nil, err
 	}
 
 	authSupported := false
@@ -48,30 +71,31 @@ func HandleSocksHandshake(conn net.Conn) (string, int, map[string]string, error)
 	}
 
 	if !authSupported {
-		return "", 0, nil, errors.New(fmt.Sprintf("no valid supported auth methods, received %v", authMethods))
+		return nil, errors.New(fmt.Sprintf("no valid supported auth methods, received %v", authMethods))
 	}
 
 	response := []byte{SocksVersion, UserPassAuth}
 	if _, err := conn.Write(response); err != nil {
-		return "", 0, nil, err
+		return nil, err
 	}
 
-	authenticated, params, err := Authenticate(conn)
-	if err != nil || !authenticated {
-		return "", 0, nil, fmt.Errorf("authentication failed: %e", err)
+	account, params, err := Authenticate(conn)
+	if err != nil {
+		return nil, fmt.Errorf("authentication failed: %w", err)
 	}
 
 	request := make([]byte, 4)
 	if _, err := io.ReadFull(conn, request); err != nil {
-		return "", 0, nil, err
+		return nil, err
 	}
 
 	if request[0] != SocksVersion {
-		return "", 0, nil, errors.New("invalid SOCKS version in request")
+		return nil, errors.New("invalid SOCKS version in request")
 	}
 
-	if request[1] != ConnectCommand {
-		return "", 0, nil, errors.New("only CONNECT command is supported")
+	if request[1] != ConnectCommand && request[1] != UDPAssociateCommand {
+		SendReply(conn, CommandNotSupported, nil)
+		return nil, fmt.Errorf("unsupported command %d", request[1])
 	}
 
 	var targetAddr string
@@ -81,38 +105,74 @@ func HandleSocksHandshake(conn net.Conn) (string, int, map[string]string, error)
 	case IPv4Address:
 		addr := make([]byte, 4)
 		if _, err := io.ReadFull(conn, addr); err != nil {
-			return "", 0, nil, err
+			return nil, err
 		}
 		targetAddr = net.IPv4(addr[0], addr[1], addr[2], addr[3]).String()
 
 	case FQDN:
 		lenByte := make([]byte, 1)
 		if _, err := io.ReadFull(conn, lenByte); err != nil {
-			return "", 0, nil, err
+			return nil, err
 		}
 		fqdnLen := int(lenByte[0])
 		fqdn := make([]byte, fqdnLen)
 		if _, err := io.ReadFull(conn, fqdn); err != nil {
-			return "", 0, nil, err
+			return nil, err
 		}
 		targetAddr = string(fqdn)
 
 	case IPv6Address:
 		addr := make([]byte, 16)
 		if _, err := io.ReadFull(conn, addr); err != nil {
-			return "", 0, nil, err
+			return nil, err
 		}
 		targetAddr = net.IP(addr).String()
 
 	default:
-		return "", 0, nil, errors.New("unsupported address type")
+		return nil, errors.New("unsupported address type")
 	}
 
 	portBytes := make([]byte, 2)
 	if _, err := io.ReadFull(conn, portBytes); err != nil {
-		return "", 0, nil, err
+		return nil, err
 	}
 	targetPort = int(binary.BigEndian.Uint16(portBytes))
 
-	return targetAddr, targetPort, params, nil
+	return &Request{
+		Version: SocksVersion,
+		Command: request[1],
+		Host:    targetAddr,
+		Port:    targetPort,
+		Account: account,
+		Params:  params,
+	}, nil
+}
+
+// Reply answers the request in the SOCKS version it was made with
+func (r *Request) Reply(conn net.Conn, status byte, bind *net.UDPAddr) error {
+	if r.Version == Socks4Version {
+		return sendSocks4Reply(conn, status)
+	}
+	return SendReply(conn, status, bind)
+}
+
+// SendReply answers a SOCKS5 request, bind is the address the server uses for it
+// and may be nil when irrelevant to the user.
+func SendReply(conn net.Conn, status byte, bind *net.UDPAddr) error {
+	reply := []byte{SocksVersion, status, 0x00}
+	if bind == nil {
+		reply = append(reply, IPv4Address, 0, 0, 0, 0, 0, 0)
+	} else {
+		if ip4 := bind.IP.To4(); ip4 != nil {
+			reply = append(reply, IPv4Address)
+			reply = append(reply, ip4...)
+		} else {
+			reply = append(reply, IPv6Address)
+			reply = append(reply, bind.IP.To16()...)
+		}
+		reply = binary.BigEndian.AppendUint16(reply, uint16(bind.Port))
+	}
+
+	_, err := conn.Write(reply)
+	return err
 }
@@ -0,0 +1,120 @@
+package socks
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+const (
+	socks4Granted  = 90
+	socks4Rejected = 91
+
+	// maxSocks4Field bounds the null-terminated user ID and hostname
+	maxSocks4Field = 255
+)
+
+/*
+SOCKS4 request, the version and command are already read:
+
+	+----+----+----------+----------+--------+------+
+	| VN | CD | DST.PORT | DST.IP   | USERID | NULL |
+	| 1B | 1B |    2B    |    4B    |  ...   |  1B  |
+	+----+----+----------+----------+--------+------+
+
+SOCKS4a sets DST.IP to 0.0.0.x with x non-zero and appends the
+null-terminated hostname. Having no password field, the user ID carries
+both as "params:password".
+*/
+func handleSocks4Request(conn net.Conn, command byte) (*Request, error) {
+	if command != ConnectCommand {
+		sendSocks4Reply(conn, GeneralFailure)
+		return nil, fmt.Errorf("unsupported SOCKS4 command %d", command)
+	}
+
+	req, err := readSocks4Request(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	account, params, err := checkCredentials(req.username, req.password)
+	if err != nil {
+		sendSocks4Reply(conn, GeneralFailure)
+		return nil, fmt.Errorf("authentication failed: %w", err)
+	}
+
+	return &Request{
+		Version: Socks4Version,
+		Command: ConnectCommand,
+		Host:    req.host,
+		Port:    req.port,
+		Account: account,
+		Params:  params,
+	}, nil
+}
+
+type socks4Request struct {
+	host               string
+	port               int
+	username, password string
+}
+
+// readSocks4Request reads the request that follows the command
+func readSocks4Request(r io.Reader) (*socks4Request, error) {
+	var dst [6]byte
+	if _, err := io.ReadFull(r, dst[:]); err != nil {
+		return nil, err
+	}
+	port := int(binary.BigEndian.Uint16(dst[:2]))
+	ip := net.IPv4(dst[2], dst[3], dst[4], dst[5])
+
+	userID, err := readNullTerminated(r)
+	if err != nil {
+		return nil, err
+	}
+
+	host := ip.String()
+	if dst[2] == 0 && dst[3] == 0 && dst[4] == 0 && dst[5] != 0 {
+		if host, err = readNullTerminated(r); err != nil {
+			return nil, err
+		}
+	}
+
+	req := &socks4Request{host: host, port: port, username: userID}
+	if i := strings.LastIndexByte(userID, ':'); i >= 0 {
+		req.username, req.password = userID[:i], userID[i+1:]
+	}
+	return req, nil
+}
+
+// readNullTerminated reads byte by byte, what follows the request is sent by
+// the user to the target and must stay unread
+func readNullTerminated(r io.Reader) (string, error) {
+	var field []byte
+	var b [1]byte
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return "", err
+		}
+		if b[0] == 0 {
+			return string(field), nil
+		}
+		if len(field) == maxSocks4Field {
+			return "", errors.New("SOCKS4 field too long")
+		}
+		field = append(field, b[0])
+	}
+}
+
+func sendSocks4Reply(conn net.Conn, status byte) error {
+	code := byte(socks4Granted)
+	if status != SuccessReply {
+		code = socks4Rejected
+	}
+
+	_, err := conn.Write([]byte{0, code, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,82 @@
+package socks
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadSocks4Request(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		want    socks4Request
+	}{
+		{
+			name:    "socks4",
+			request: "\x01\xbb\x5d\xb8\xd8\x22" + "country=US:secret\x00",
+			want:    socks4Request{host: "93.184.216.34", port: 443, username: "country=US", password: "secret"},
+		},
+		{
+			name:    "socks4a",
+			request: "\x00\x50\x00\x00\x00\x01" + "user:key\x00" + "example.com\x00",
+			want:    socks4Request{host: "example.com", port: 80, username: "user", password: "key"},
+		},
+		{
+			name:    "password after the last colon",
+			request: "\x00\x50\x00\x00\x00\x01" + "a:b:key\x00" + "example.com\x00",
+			want:    socks4Request{host: "example.com", port: 80, username: "a:b", password: "key"},
+		},
+		{
+			name:    "no password",
+			request: "\x00\x50\x01\x02\x03\x04" + "user\x00",
+			want:    socks4Request{host: "1.2.3.4", port: 80, username: "user"},
+		},
+		{
+			name:    "0.0.0.0 is not socks4a",
+			request: "\x00\x50\x00\x00\x00\x00" + "\x00",
+			want:    socks4Request{host: "0.0.0.0", port: 80},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the data the user sends right behind the request stays unread
+			r := strings.NewReader(tt.request + "GET /")
+			got, err := readSocks4Request(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Fatalf("got %+v, want %+v", *got, tt.want)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "GET /" {
+				t.Fatalf("read past the request, %q left", rest)
+			}
+		})
+	}
+}
+
+func TestReadSocks4RequestInvalid(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), maxSocks4Field+1)
+
+	tests := []struct {
+		name    string
+		request string
+	}{
+		{"truncated address", "\x00\x50\x01"},
+		{"unterminated user ID", "\x00\x50\x01\x02\x03\x04user"},
+		{"unterminated hostname", "\x00\x50\x00\x00\x00\x01user\x00example.com"},
+		{"user ID too long", "\x00\x50\x01\x02\x03\x04" + string(long) + "\x00"},
+		{"hostname too long", "\x00\x50\x00\x00\x00\x01\x00" + string(long) + "\x00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if req, err := readSocks4Request(strings.NewReader(tt.request)); err == nil {
+				t.Fatalf("accepted %+v", *req)
+			}
+		})
+	}
+}
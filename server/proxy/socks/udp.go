@@ -0,0 +1,73 @@
+package socks
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+var errInvalidDatagram = errors.New("invalid SOCKS UDP datagram")
+
+/*
+UDP request header, RFC 1928 section 7:
+
+	+-----+------+------+----------+----------+----------+
+	| RSV | FRAG | ATYP | DST.ADDR | DST.PORT |   DATA   |
+	| 2B  |  1B  |  1B  | Variable |    2B    | Variable |
+	+-----+------+------+----------+----------+----------+
+*/
+
+// ParseUDPDatagram returns the target as host:port and the payload.
+// Fragmented datagrams are not supported and rejected.
+func ParseUDPDatagram(b []byte) (string, []byte, error) {
+	if len(b) < 4 || b[2] != 0 {
+		return "", nil, errInvalidDatagram
+	}
+
+	var host string
+	rest := b[4:]
+	switch b[3] {
+	case IPv4Address:
+		if len(rest) < 4 {
+			return "", nil, errInvalidDatagram
+		}
+		host = net.IP(rest[:4]).String()
+		rest = rest[4:]
+	case IPv6Address:
+		if len(rest) < 16 {
+			return "", nil, errInvalidDatagram
+		}
+		host = net.IP(rest[:16]).String()
+		rest = rest[16:]
+	case FQDN:
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return "", nil, errInvalidDatagram
+		}
+		host = string(rest[1 : 1+int(rest[0])])
+		rest = rest[1+int(rest[0]):]
+	default:
+		return "", nil, errInvalidDatagram
+	}
+
+	if len(rest) < 2 {
+		return "", nil, errInvalidDatagram
+	}
+	port := binary.BigEndian.Uint16(rest)
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), rest[2:], nil
+}
+
+// AppendUDPHeader appends the header of a datagram received from source
+func AppendUDPHeader(buf []byte, source netip.AddrPort) []byte {
+	buf = append(buf, 0, 0, 0)
+	addr := source.Addr().Unmap()
+	if addr.Is4() {
+		buf = append(buf, IPv4Address)
+	} else {
+		buf = append(buf, IPv6Address)
+	}
+	buf = append(buf, addr.AsSlice()...)
+	return binary.BigEndian.AppendUint16(buf, source.Port())
+}
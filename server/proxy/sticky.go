@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"server/database"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStickyTTL = 10 * time.Minute
+	maxStickyTTL     = 24 * time.Hour
+)
+
+// selector picks the nodes a connection of the user is tried on. Connections
+// of a sticky session exit from the node pinned to it for the session TTL.
+type selector struct {
+	target
+	account string     // ID of the account, the destination policy may be overridden per account
+	sticky  *stickyKey // nil outside of a session
+	ttl     time.Duration
+}
+
+// target is the location requested by the user, region and city are only
+// honoured together with a country.
+type target struct {
+	country string
+	region  string
+	city    string
+	asn     string
+}
+
+type stickyKey struct {
+	account string
+	session string
+}
+
+type stickySession struct {
+	nodeID  string
+	target  target
+	expires time.Time
+}
+
+var (
+	stickySessions = make(map[stickyKey]*stickySession)
+	stickyMutex    sync.Mutex
+)
+
+func newSelector(account *database.Account, params map[string]string) selector {
+	sel := selector{target: target{country: "global"}, ttl: defaultStickyTTL}
+	if country, exists := params["country"]; exists {
+		sel.country = country
+		sel.region = params["region"]
+		sel.city = params["city"]
+	}
+	sel.asn = params["asn"]
+	if account != nil {
+		sel.account = account.ID
+	}
+
+	if session, exists := params["sessionId"]; exists && account != nil {
+		sel.sticky = &stickyKey{account: account.ID, session: session}
+	}
+	if minutes, err := strconv.Atoi(params["sessTime"]); err == nil {
+		sel.ttl = min(time.Duration(minutes)*time.Minute, maxStickyTTL)
+	}
+
+	return sel
+}
+
+// pick returns the pinned node of the session while it is connected and
+// advertises capability, or a node of the most specific pool of the target
+// that has one. An empty capability matches every node, the nodes whose ID
+// is in tried are skipped.
+func (s selector) pick(capability string, tried map[string]bool) *QuicClient {
+	accept := func(client *QuicClient) bool {
+		return !tried[client.ID] && (capability == "" || client.HasCapability(capability))
+	}
+
+	if client := s.pinned(); client != nil && accept(client) {
+		return client
+	}
+
+	for _, key := range s.poolKeys() {
+		if client := findClient(key, accept); client != nil {
+			return client
+		}
+	}
+	return nil
+}
+
+// poolKeys lists the pools of the target from the most specific one down to
+// the country. The ISP is preferred over the city and region, the country is
+// never left.
+func (t target) poolKeys() []string {
+	var keys []string
+	if t.asn != "" {
+		keys = append(keys, asnKey(t.country, t.asn))
+	}
+
+	if t.country != "global" {
+		if t.city != "" {
+			keys = append(keys, cityKey(t.country, t.region, t.city))
+		}
+		if t.region != "" {
+			keys = append(keys, regionKey(t.country, t.region))
+		}
+	}
+	return append(keys, t.country)
+}
+
+func (s selector) pinned() *QuicClient {
+	if s.sticky == nil {
+		return nil
+	}
+
+	stickyMutex.Lock()
+	session, ok := stickySessions[*s.sticky]
+	stickyMutex.Unlock()
+	if !ok || session.target != s.target || time.Now().After(session.expires) {
+		return nil
+	}
+
+	QuicMutex.RLock()
+	client := QuicClients[session.nodeID]
+	QuicMutex.RUnlock()
+	if !client.isHealthy() {
+		return nil
+	}
+	return client
+}
+
+// pin makes client the node of the session, unless the session still has a
+// connected node: a connection that failed over does not move the session.
+func (s selector) pin(client *QuicClient) {
+	if s.sticky == nil || s.pinned() != nil {
+		return
+	}
+
+	stickyMutex.Lock()
+	stickySessions[*s.sticky] = &stickySession{
+		nodeID:  client.ID,
+		target:  s.target,
+		expires: time.Now().Add(s.ttl),
+	}
+	stickyMutex.Unlock()
+}
+
+func sweepStickySessions() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		stickyMutex.Lock()
+		for key, session := range stickySessions {
+			if now.After(session.expires) {
+				delete(stickySessions, key)
+			}
+		}
+		stickyMutex.Unlock()
+	}
+}
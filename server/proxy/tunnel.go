@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// tunnel carries a single user connection through a node
+type tunnel interface {
+	io.ReadWriteCloser
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	// CloseWrite tells the node that the user will not send more data
+	CloseWrite() error
+	// Abort discards the data in flight in both directions
+	Abort()
+}
+
+// streamTunnel relays a connection over its own QUIC stream, which gives
+// every connection its own ordering and flow control.
+type streamTunnel struct {
+	*quic.Stream
+}
+
+func (t streamTunnel) Close() error {
+	t.CancelRead(0)
+	return t.Stream.Close()
+}
+
+func (t streamTunnel) CloseWrite() error {
+	return t.Stream.Close()
+}
+
+func (t streamTunnel) Abort() {
+	t.CancelRead(0)
+	t.CancelWrite(0)
+}
+
+// openTunnel asks the node to connect to addr, the node answers with a
+// connected frame once it reached the target.
+func (c *QuicClient) openTunnel(pc *Connection, addr string) (tunnel, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	stream, err := c.conn.OpenStreamSync(ctx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	t := streamTunnel{stream}
+
+	c.userMutex.Lock()
+	pc.tunnel = t
+	c.userConns[pc.ID] = pc
+	c.userMutex.Unlock()
+	atomic.AddInt32(&c.Stats.ActiveConns, 1)
+
+	header, err := appendFrame(nil, Message{Type: MsgConnect, ID: pc.ID, Addr: addr})
+	if err == nil {
+		_, err = stream.Write(header)
+	}
+	if err != nil {
+		c.detachConnection(pc.ID)
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// detachConnection closes the tunnel of a connection but leaves the user side open
+func (c *QuicClient) detachConnection(id uint32) *Connection {
+	c.userMutex.Lock()
+	pc, ok := c.userConns[id]
+	delete(c.userConns, id)
+	c.userMutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	pc.tunnel.Close()
+	atomic.AddInt32(&c.Stats.ActiveConns, -1)
+	return pc
+}
+
+func (c *QuicClient) closeAllConnections() {
+	c.userMutex.Lock()
+	ids := make([]uint32, 0, len(c.userConns))
+	for id := range c.userConns {
+		ids = append(ids, id)
+	}
+	assocs := make([]uint32, 0, len(c.udpAssocs))
+	for id := range c.udpAssocs {
+		assocs = append(assocs, id)
+	}
+	c.userMutex.Unlock()
+
+	for _, id := range ids {
+		c.closeConnection(id)
+	}
+	for _, id := range assocs {
+		c.closeAssociation(id)
+	}
+}
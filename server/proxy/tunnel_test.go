@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// connectNode returns both ends of a QUIC connection between the server and
+// a node, configured as in production
+func connectNode(t *testing.T) (server, node *quic.Conn) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{ProtocolFrame},
+	}, quicConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	node, err = quic.DialAddr(ctx, listener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{ProtocolFrame},
+	}, quicConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.CloseWithError(0, "") })
+
+	server, err = listener.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, node
+}
+
+func TestStalledStreamDoesNotBlockNeighbours(t *testing.T) {
+	server, node := connectNode(t)
+
+	// more than the connection window of the server, which only goes
+	// through if the stalled user holds no more than its own stream window
+	size := int64(quicConfig.MaxConnectionReceiveWindow + quicConfig.MaxStreamReceiveWindow)
+
+	// the node only sees a stream once the server writes to it, the first
+	// byte tells the two user connections apart
+	const stalled, flowing = 1, 2
+	streams := make(map[byte]*quic.Stream, 2)
+	for _, id := range []byte{stalled, flowing} {
+		stream, err := server.OpenStreamSync(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Write([]byte{id}); err != nil {
+			t.Fatal(err)
+		}
+		defer streamTunnel{stream}.Abort()
+		streams[id] = stream
+	}
+
+	// the node relays as fast as the server lets it on both streams
+	written := make(map[byte]chan int64, 2)
+	for range 2 {
+		stream, err := node.AcceptStream(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var id [1]byte
+		if _, err := io.ReadFull(stream, id[:]); err != nil {
+			t.Fatal(err)
+		}
+
+		copied := make(chan int64, 1)
+		written[id[0]] = copied
+		go func() {
+			n, _ := io.CopyN(stream, zeroReader{}, size)
+			copied <- n
+		}()
+	}
+
+	streams[flowing].SetReadDeadline(time.Now().Add(20 * time.Second))
+	n, err := io.Copy(io.Discard, io.LimitReader(streams[flowing], size))
+	if err != nil || n != size {
+		t.Fatalf("flowing connection received %d of %d bytes: %v", n, size, err)
+	}
+
+	select {
+	case n := <-written[stalled]:
+		t.Fatalf("node relayed %d bytes to a user that reads nothing", n)
+	default:
+	}
+}
+
+func TestStalledUserIsDropped(t *testing.T) {
+	defer func(timeout time.Duration) { stallTimeout = timeout }(stallTimeout)
+	stallTimeout = 50 * time.Millisecond
+
+	user, peer := net.Pipe()
+	defer user.Close()
+	defer peer.Close()
+
+	var received uint64
+	m := &meter{w: user, counter: &received, packets: make(map[int64]uint16), start: time.Now()}
+
+	// net.Pipe is unbuffered, nothing is drained as the user never reads
+	_, err := io.Copy(m, bytes.NewReader([]byte("response")))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the stalled write to time out, got %v", err)
+	}
+	if received != 0 {
+		t.Fatalf("counted %d bytes that were never delivered", received)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
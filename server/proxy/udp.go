@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"server/proxy/socks"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// CapabilityUDP is advertised by nodes that relay UDP associations
+const CapabilityUDP = "udp"
+
+// udpIdleTimeout ends associations that relayed no datagram for this long
+const udpIdleTimeout = 2 * time.Minute
+
+// udpAssociation relays the datagrams of a SOCKS UDP ASSOCIATE request
+// through a node, it lives as long as the TCP connection of the request.
+type udpAssociation struct {
+	id      uint32
+	client  *QuicClient
+	control net.Conn
+	conn    *net.UDPConn
+	usage   *usage
+	account string
+
+	userIP     netip.Addr
+	user       atomic.Pointer[netip.AddrPort] // learned from the first datagram
+	lastActive atomic.Int64
+}
+
+func handleUDPAssociate(conn net.Conn, req *socks.Request) {
+	u, detach, err := trackUsage(req.Account, conn)
+	if err != nil {
+		log.Printf("UDP associate for %s refused: %v", conn.RemoteAddr(), err)
+		socks.SendReply(conn, socks.NotAllowed, nil)
+		return
+	}
+	defer detach()
+
+	sel := newSelector(req.Account, req.Params)
+	client := sel.pick(CapabilityUDP, nil)
+	if client == nil {
+		log.Println("No available UDP clients found for this request")
+		socks.SendReply(conn, socks.GeneralFailure, nil)
+		return
+	}
+
+	local := conn.LocalAddr().(*net.TCPAddr)
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP})
+	if err != nil {
+		log.Printf("Failed to open UDP relay for %s: %v", conn.RemoteAddr(), err)
+		socks.SendReply(conn, socks.GeneralFailure, nil)
+		return
+	}
+
+	sel.pin(client)
+
+	a := &udpAssociation{
+		id:      nextID.Add(1),
+		client:  client,
+		control: conn,
+		conn:    udpConn,
+		usage:   u,
+		account: sel.account,
+		userIP:  conn.RemoteAddr().(*net.TCPAddr).AddrPort().Addr().Unmap(),
+	}
+	a.touch()
+
+	client.userMutex.Lock()
+	client.udpAssocs[a.id] = a
+	client.userMutex.Unlock()
+	atomic.AddInt32(&client.Stats.ActiveConns, 1)
+	defer client.closeAssociation(a.id)
+
+	if err := socks.SendReply(conn, socks.SuccessReply, udpConn.LocalAddr().(*net.UDPAddr)); err != nil {
+		return
+	}
+
+	go a.relayFromUser()
+
+	// the user sends nothing more on the TCP connection, reading it only
+	// tells when it is closed
+	buf := make([]byte, 512)
+	for {
+		conn.SetReadDeadline(a.idleDeadline())
+		_, err := conn.Read(buf)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) && time.Now().Before(a.idleDeadline()) {
+			continue
+		}
+		return
+	}
+}
+
+func (a *udpAssociation) touch() {
+	a.lastActive.Store(time.Now().UnixNano())
+}
+
+func (a *udpAssociation) idleDeadline() time.Time {
+	return time.Unix(0, a.lastActive.Load()).Add(udpIdleTimeout)
+}
+
+func (a *udpAssociation) relayFromUser() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, from, err := a.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+
+		// only the user of the SOCKS connection may use the association
+		if from.Addr().Unmap() != a.userIP {
+			continue
+		}
+
+		addr, payload, err := socks.ParseUDPDatagram(buf[:n])
+		if err != nil {
+			continue
+		}
+		if err := checkDestination(a.account, addr); err != nil {
+			continue
+		}
+		a.user.Store(&from)
+		a.touch()
+		if !a.usage.allowDatagram(len(payload)) {
+			continue
+		}
+
+		err = a.client.sendDatagram(Message{Type: MsgDatagram, ID: a.id, Addr: addr, Data: payload})
+		if err != nil {
+			log.Printf("Failed to relay datagram on client %s: %v", a.client.ID, err)
+			continue
+		}
+		atomic.AddUint64(&a.client.Stats.BytesSent, uint64(len(payload)))
+		a.usage.charge(len(payload))
+	}
+}
+
+// deliver sends a datagram the node received from msg.Addr to the user
+func (a *udpAssociation) deliver(msg Message) {
+	user := a.user.Load()
+	if user == nil {
+		return
+	}
+
+	source, err := netip.ParseAddrPort(msg.Addr)
+	if err != nil {
+		return
+	}
+
+	if !a.usage.allowDatagram(len(msg.Data)) {
+		return
+	}
+
+	packet := socks.AppendUDPHeader(make([]byte, 0, 22+len(msg.Data)), source)
+	packet = append(packet, msg.Data...)
+	if _, err := a.conn.WriteToUDPAddrPort(packet, *user); err != nil {
+		return
+	}
+	a.touch()
+	atomic.AddUint64(&a.client.Stats.BytesReceived, uint64(len(msg.Data)))
+	a.usage.charge(len(msg.Data))
+}
+
+// sendDatagram uses a QUIC datagram when it fits and the control stream
+// otherwise, datagrams are never split.
+func (c *QuicClient) sendDatagram(msg Message) error {
+	if c.conn.ConnectionState().SupportsDatagrams {
+		frame, err := appendFrame(nil, msg)
+		if err != nil {
+			return err
+		}
+
+		var tooLarge *quic.DatagramTooLargeError
+		if err := c.conn.SendDatagram(frame); !errors.As(err, &tooLarge) {
+			return err
+		}
+	}
+	return c.SendMessage(msg)
+}
+
+func (c *QuicClient) receiveDatagrams() {
+	for {
+		b, err := c.conn.ReceiveDatagram(c.conn.Context())
+		if err != nil {
+			return
+		}
+
+		msg, err := readFrame(bytes.NewReader(b))
+		if err != nil || msg.Type != MsgDatagram {
+			continue
+		}
+		c.deliverDatagram(msg)
+	}
+}
+
+func (c *QuicClient) deliverDatagram(msg Message) {
+	c.userMutex.Lock()
+	a := c.udpAssocs[msg.ID]
+	c.userMutex.Unlock()
+
+	if a != nil {
+		a.deliver(msg)
+	}
+}
+
+// closeAssociation tells the node to release the sockets of the association
+func (c *QuicClient) closeAssociation(id uint32) {
+	c.userMutex.Lock()
+	a, ok := c.udpAssocs[id]
+	delete(c.udpAssocs, id)
+	c.userMutex.Unlock()
+
+	if !ok {
+		return
+	}
+
+	a.conn.Close()
+	a.control.Close()
+	c.SendMessage(Message{Type: MsgClose, ID: id})
+	atomic.AddInt32(&c.Stats.ActiveConns, -1)
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"io"
+	"log"
+	"server/data"
+	"server/database"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// usageFlushInterval batches the charges of an account into a single
+// HINCRBY, the balance may go that much traffic below zero.
+const usageFlushInterval = 5 * time.Second
+
+// usage charges the traffic of an account to its credits and cuts its
+// connections once they are used up, it also enforces the limits of the
+// plan of the account. A nil usage is unmetered and unlimited.
+type usage struct {
+	account   *database.Account
+	pending   atomic.Int64 // bytes not yet charged in redis
+	balance   atomic.Int64 // as of the last read from redis
+	exhausted atomic.Bool
+
+	mutex     sync.Mutex
+	conns     map[io.Closer]struct{}
+	limits    database.Limits
+	requests  atomic.Pointer[tokenBucket]
+	bandwidth atomic.Pointer[tokenBucket]
+}
+
+var (
+	usages     = make(map[string]*usage) // account ID -> usage
+	usageMutex sync.Mutex
+
+	// chargeCredits takes bytes off the balance in redis, replaced in tests
+	chargeCredits = (*database.Account).ChargeCredits
+)
+
+// trackUsage returns the usage of account, shared by all its connections,
+// and registers conn to be closed when the credits run out. detach must be
+// called once the connection is done. A connection over the limits of the
+// account is refused with a *limitError, and with database.ErrNoCredits
+// while the credits are used up. The usage is nil for unmetered accounts.
+func trackUsage(account *database.Account, conn io.Closer) (u *usage, detach func(), err error) {
+	if account == nil || !account.Metered() {
+		return nil, func() {}, nil
+	}
+
+	usageMutex.Lock()
+	u, ok := usages[account.ID]
+	if !ok {
+		u = &usage{account: account, conns: make(map[io.Closer]struct{})}
+		u.balance.Store(int64(account.Credits))
+		usages[account.ID] = u
+	}
+	err = u.admit(account.Limits, conn)
+	usageMutex.Unlock()
+	if err != nil {
+		data.LogThrottled(err.(*limitError).limit)
+		return nil, nil, err
+	}
+
+	detach = func() {
+		u.mutex.Lock()
+		delete(u.conns, conn)
+		u.mutex.Unlock()
+	}
+
+	// the account may come from the auth cache, only the balance in redis
+	// tells whether it was topped up
+	if u.exhausted.Load() {
+		balance, err := account.FetchCredits()
+		if err != nil || balance <= u.pending.Load() {
+			detach()
+			if err != nil {
+				log.Printf("Cannot check the credits of account %s: %v", account.ID, err)
+			}
+			return nil, nil, database.ErrNoCredits
+		}
+		u.balance.Store(balance)
+		u.exhausted.Store(false)
+	}
+
+	return u, detach, nil
+}
+
+// admit registers conn unless the account is over its limits, which are
+// updated to the latest read from redis
+func (u *usage) admit(limits database.Limits, conn io.Closer) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if limits != u.limits {
+		u.limits = limits
+		perMinute := float64(limits.RequestsPerMinute)
+		u.requests.Store(newTokenBucket(perMinute/60, perMinute))
+		u.bandwidth.Store(newTokenBucket(float64(limits.Bandwidth), float64(limits.Bandwidth)))
+	}
+
+	if limits.MaxConns > 0 && len(u.conns) >= limits.MaxConns {
+		return &limitError{limit: LimitConnections}
+	}
+	if !u.requests.Load().allow(1) {
+		return &limitError{limit: LimitRequests}
+	}
+
+	u.conns[conn] = struct{}{}
+	return nil
+}
+
+func (u *usage) charge(n int) {
+	if u == nil || n <= 0 {
+		return
+	}
+
+	pending := u.pending.Add(int64(n))
+	if pending >= u.balance.Load() && u.exhausted.CompareAndSwap(false, true) {
+		// don't wait for the next flush to stop the traffic
+		go func() {
+			u.flush()
+			if u.balance.Load() > 0 {
+				u.exhausted.Store(false) // topped up meanwhile
+				return
+			}
+			u.cut()
+		}()
+	}
+}
+
+// flush charges the pending traffic to the account
+func (u *usage) flush() {
+	pending := u.pending.Swap(0)
+	if pending == 0 {
+		return
+	}
+
+	balance, err := chargeCredits(u.account, pending)
+	if err != nil {
+		u.pending.Add(pending) // retried on the next flush
+		log.Printf("Failed to charge %d bytes to account %s: %v", pending, u.account.ID, err)
+		return
+	}
+
+	u.balance.Store(balance)
+	if balance <= 0 && u.exhausted.CompareAndSwap(false, true) {
+		u.cut()
+	}
+}
+
+func (u *usage) cut() {
+	u.mutex.Lock()
+	conns := make([]io.Closer, 0, len(u.conns))
+	for conn := range u.conns {
+		conns = append(conns, conn)
+	}
+	u.mutex.Unlock()
+
+	if len(conns) > 0 {
+		log.Printf("Account %s ran out of credits, closing %d connections", u.account.ID, len(conns))
+	}
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// idle usages are forgotten, the limits of the account start over
+func (u *usage) idle() bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return len(u.conns) == 0 && u.pending.Load() == 0 && u.requests.Load().full() && u.bandwidth.Load().full()
+}
+
+// flushUsage charges the traffic of every account each usageFlushInterval
+// and forgets the accounts without connections.
+func flushUsage() {
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		usageMutex.Lock()
+		tracked := make([]*usage, 0, len(usages))
+		for _, u := range usages {
+			tracked = append(tracked, u)
+		}
+		usageMutex.Unlock()
+
+		for _, u := range tracked {
+			u.flush()
+		}
+
+		usageMutex.Lock()
+		for id, u := range usages {
+			if u.idle() {
+				delete(usages, id)
+			}
+		}
+		usageMutex.Unlock()
+	}
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"io"
+	"server/database"
+	"testing"
+	"time"
+)
+
+type closer chan struct{}
+
+func (c closer) Close() error {
+	close(c)
+	return nil
+}
+
+// meteredUsage returns the usage of an account with balance bytes of credit
+// left in redis, which charges come out of
+func meteredUsage(t *testing.T, balance int64) (*usage, closer) {
+	t.Helper()
+
+	charge := chargeCredits
+	t.Cleanup(func() { chargeCredits = charge })
+
+	remote := balance
+	chargeCredits = func(_ *database.Account, bytes int64) (int64, error) {
+		remote -= bytes
+		return remote, nil
+	}
+
+	conn := make(closer)
+	u := &usage{account: &database.Account{ID: "test"}, conns: map[io.Closer]struct{}{conn: {}}}
+	u.balance.Store(balance)
+	return u, conn
+}
+
+func TestUsageCutAtZero(t *testing.T) {
+	u, conn := meteredUsage(t, 1000)
+
+	u.charge(600)
+	u.charge(399)
+	if u.exhausted.Load() {
+		t.Fatal("usage exhausted with credits left")
+	}
+
+	u.charge(1)
+	select {
+	case <-conn:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection still open once the credits ran out")
+	}
+	if !u.exhausted.Load() || u.balance.Load() != 0 || u.pending.Load() != 0 {
+		t.Fatalf("exhausted %v with %d credits and %d bytes pending, want the whole usage charged",
+			u.exhausted.Load(), u.balance.Load(), u.pending.Load())
+	}
+}
+
+func TestUsageToppedUp(t *testing.T) {
+	u, conn := meteredUsage(t, 1000)
+
+	// the cached balance is stale, redis holds more
+	u.balance.Store(100)
+	u.charge(100)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for u.exhausted.Load() || u.pending.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("usage still exhausted after the charge")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-conn:
+		t.Fatal("connection cut with credits left")
+	default:
+	}
+	if balance := u.balance.Load(); balance != 900 {
+		t.Fatalf("balance is %d, want 900", balance)
+	}
+}
+
+func TestUnmeteredUsage(t *testing.T) {
+	u, detach, err := trackUsage(&database.Account{ID: "debug"}, make(closer))
+	if err != nil || u != nil {
+		t.Fatalf("unmetered account got usage %v, %v", u, err)
+	}
+	detach()
+
+	// a nil usage charges nothing and never throttles
+	u.charge(1 << 30)
+	u.throttle(1 << 30)
+	if !u.allowDatagram(1 << 30) {
+		t.Fatal("unmetered datagram dropped")
+	}
+}
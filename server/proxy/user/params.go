@@ -1,10 +1,13 @@
 package user
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+)
 
 func ParseParams(paramStr string) map[string]string {
 	params := make(map[string]string)
-	// Example paramStr: "country=US,sessionId=abc123" or "resid_ip,US"
+	// Example paramStr: "country=US,region=california,city=los_angeles,asn=AS7922,sessionId=abc123" or "resid_ip,US"
 	pairs := strings.Split(paramStr, ",")
 	for _, pair := range pairs {
 		kv := strings.Split(pair, "=")
@@ -29,6 +32,19 @@ func ParseParams(paramStr string) map[string]string {
 			if IsValidCountryCode(strings.ToUpper(value)) {
 				params[key] = strings.ToUpper(value)
 			}
+		case "region", "city":
+			if location := NormalizeLocation(value); location != "" {
+				params[key] = location
+			}
+		case "asn":
+			if asn, ok := ParseASN(value); ok {
+				params[key] = asn
+			}
+		case "sesstime":
+			// minutes a sticky session keeps its node
+			if minutes, err := strconv.Atoi(value); err == nil && minutes > 0 {
+				params["sessTime"] = value
+			}
 		default:
 			if strings.Contains(key, "sess") {
 				params["sessionId"] = value
@@ -38,6 +54,23 @@ func ParseParams(paramStr string) map[string]string {
 	return params
 }
 
+// NormalizeLocation folds a region or city name so that user input like
+// "Los_Angeles" matches the geolocated "Los Angeles"
+func NormalizeLocation(name string) string {
+	name = strings.NewReplacer("_", " ", "+", " ").Replace(name)
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// ParseASN accepts "AS15169" as well as "15169" and returns the number
+func ParseASN(value string) (string, bool) {
+	value = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "AS")
+	asn, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || asn == 0 {
+		return "", false
+	}
+	return strconv.FormatUint(asn, 10), true
+}
+
 var validCountryCodes = map[string]struct{}{
 	"AD": {}, "AE": {}, "AF": {}, "AG": {}, "AI": {}, "AL": {}, "AM": {}, "AO": {},
 	"AQ": {}, "AR": {}, "AS": {}, "AT": {}, "AU": {}, "AW": {}, "AX": {}, "AZ": {},
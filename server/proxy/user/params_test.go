@@ -0,0 +1,78 @@
+package user
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseParams(t *testing.T) {
+	tests := []struct {
+		params string
+		want   map[string]string
+	}{
+		{"", map[string]string{}},
+		{"country=us", map[string]string{"country": "US"}},
+		{"country=XX", map[string]string{}},
+		{"resid_ip,US", map[string]string{"group": "residential", "country": "US"}},
+		{"de,fr", map[string]string{"country": "DE"}},
+		{"country=US,region=New_York,city=new+york", map[string]string{"country": "US", "region": "new york", "city": "new york"}},
+		{"asn=AS7922", map[string]string{"asn": "7922"}},
+		{"asn=as0", map[string]string{}},
+		{"sessionId=abc123,sessTime=10", map[string]string{"sessionId": "abc123", "sessTime": "10"}},
+		{"sessTime=0,sessTime=soon", map[string]string{}},
+		{"region=_", map[string]string{}},
+		{"a=b=c", map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseParams(tt.params); !maps.Equal(got, tt.want) {
+			t.Errorf("ParseParams(%q) = %v, want %v", tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestParseASN(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		ok    bool
+	}{
+		{"AS15169", "15169", true},
+		{"as15169", "15169", true},
+		{" 15169 ", "15169", true},
+		{"AS015169", "15169", true},
+		{"AS0", "", false},
+		{"AS", "", false},
+		{"AS-1", "", false},
+		{"4294967296", "", false},
+		{"google", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseASN(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseASN(%q) = %q, %v, want %q, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNormalizeLocation(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Los Angeles", "los angeles"},
+		{"Los_Angeles", "los angeles"},
+		{"los+angeles", "los angeles"},
+		{"  Los   Angeles ", "los angeles"},
+		{"São Paulo", "são paulo"},
+		{"_", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeLocation(tt.name); got != tt.want {
+			t.Errorf("NormalizeLocation(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math"
 	"net/http"
 	"server/proxy"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -15,6 +16,11 @@ var templates, _ = template.ParseFiles("./website/templates/stats.html")
 type ClientData struct {
 	ID              string
 	CryptoAddr      string
+	Version         string
+	Platform        string
+	Location        string
+	ISP             string
+	Sharing         string
 	ActiveTime      string
 	ActiveConns     int32
 	BytesIn         string
@@ -40,7 +46,7 @@ func getClientData(id string, client *proxy.QuicClient) ClientData {
 	activeTime := time.Since(client.Stats.ConnectTime).Round(time.Second)
 	activeConns := atomic.LoadInt32(&client.Stats.ActiveConns)
 
-	return ClientData{
+	data := ClientData{
 		ID:              id,
 		CryptoAddr:      client.Stats.CryptoAddr,
 		ActiveTime:      activeTime.String(),
@@ -52,6 +58,30 @@ func getClientData(id string, client *proxy.QuicClient) ClientData {
 		Score:           fmt.Sprintf("%.0f/100", client.Metrics.Score),
 		EstimatedReward: fmt.Sprintf("$%.4f", float64(totalBytes)/math.Pow10(9)*0.10), //0. TODO: proper reward calculation
 	}
+	if client.Stats.CountryCode != "global" {
+		location := []string{client.Stats.CountryCode}
+		for _, name := range []string{client.Stats.Region, client.Stats.City} {
+			if name != "" {
+				location = append(location, name)
+			}
+		}
+		data.Location = strings.Join(location, " / ")
+	}
+	if client.Stats.ASN != "" {
+		data.ISP = fmt.Sprintf("%s (AS%s)", client.Stats.ISP, client.Stats.ASN)
+	}
+	data.Sharing = "Yes"
+	if reason := client.Unavailable(); reason != "" {
+		data.Sharing = "Paused (" + strings.ReplaceAll(reason, "_", " ") + ")"
+	}
+	if client.Info != nil {
+		data.Version = client.Info.Version
+		if client.Info.OS != "" {
+			data.Platform = client.Info.OS + "/" + client.Info.Arch
+		}
+	}
+
+	return data
 }
 
 func StatsHandler(w http.ResponseWriter, r *http.Request) {
@@ -60,6 +90,11 @@ func StatsHandler(w http.ResponseWriter, r *http.Request) {
 	headers := []string{
 		"Client",
 		"Crypto Address",
+		"Version",
+		"Platform",
+		"Location",
+		"ISP",
+		"Sharing",
 		"Active Since",
 		"Active Connections",
 		"Bytes Received",
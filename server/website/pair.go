@@ -0,0 +1,62 @@
+package website
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"server/proxy"
+	"strings"
+)
+
+// pairingSecret authenticates the dashboard backend, which claims pairing
+// codes on behalf of its signed in users. Pairing by code is disabled
+// without it.
+var pairingSecret = os.Getenv("PAIRING_SECRET")
+
+type pairRequest struct {
+	Code string `json:"code"`
+	UID  string `json:"uid"`
+}
+
+// PairHandler pairs the node showing a code with an account:
+//
+//	POST /pair
+//	Authorization: Bearer <PAIRING_SECRET>
+//	{"code": "ABCD-EFGH", "uid": "<account uid>"}
+func PairHandler(w http.ResponseWriter, r *http.Request) {
+	if pairingSecret == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(pairingSecret)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req pairRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.Code == "" || req.UID == "" {
+		http.Error(w, "Expected a JSON body with code and uid", http.StatusBadRequest)
+		return
+	}
+
+	err := proxy.PairWithCode(req.Code, req.UID)
+	switch {
+	case errors.Is(err, proxy.ErrUnknownPairingCode):
+		http.Error(w, "Unknown or expired pairing code", http.StatusNotFound)
+	case err != nil:
+		log.Printf("Pairing with code failed: %v", err)
+		http.Error(w, "Pairing failed", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,56 @@
+import { NextApiRequest, NextApiResponse } from 'next';
+import { supabase } from '@/lib/supabase';
+
+// Claims the pairing code printed by a headless node for the signed in user.
+// The proxy server trusts this backend through PAIRING_SECRET.
+export default async function handler(
+  req: NextApiRequest,
+  res: NextApiResponse
+) {
+  if (req.method !== 'POST') {
+    return res.status(405).json({ error: 'Method not allowed' });
+  }
+
+  const serverUrl = process.env.PROXY_SERVER_URL;
+  const secret = process.env.PAIRING_SECRET;
+  if (!serverUrl || !secret) {
+    return res.status(503).json({ error: 'Pairing by code is not available' });
+  }
+
+  const accessToken = req.headers.authorization?.replace(/^Bearer /, '');
+  if (!accessToken) {
+    return res.status(401).json({ error: 'Not authenticated' });
+  }
+
+  const { data: { user }, error } = await supabase.auth.getUser(accessToken);
+  if (error || !user) {
+    return res.status(401).json({ error: 'Not authenticated' });
+  }
+
+  const { code } = req.body ?? {};
+  if (typeof code !== 'string' || !code.trim()) {
+    return res.status(400).json({ error: 'Missing pairing code' });
+  }
+
+  try {
+    const response = await fetch(`${serverUrl}/pair`, {
+      method: 'POST',
+      headers: {
+        'Authorization': `Bearer ${secret}`,
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ code: code.trim(), uid: user.id }),
+    });
+
+    if (response.status === 404) {
+      return res.status(404).json({ error: 'Unknown or expired pairing code' });
+    }
+    if (!response.ok) {
+      throw new Error(`Proxy server responded with status: ${response.status}`);
+    }
+    return res.status(200).json({ paired: true });
+  } catch (err) {
+    console.error('Failed to pair node:', err);
+    return res.status(502).json({ error: 'Pairing failed, try again later' });
+  }
+}
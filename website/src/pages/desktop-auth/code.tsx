@@ -0,0 +1,159 @@
+import { useEffect, useState } from 'react';
+import { useRouter } from 'next/router';
+import { useAuth } from '@/hooks/useAuth';
+import { Card, CardContent, CardDescription, CardHeader, CardTitle, CardFooter } from '@/components/ui/card';
+import { Loader2, Check, AlertCircle, LogIn, Server } from 'lucide-react';
+import { Button } from '@/components/ui/button';
+
+// Pairs a headless node with the code it printed in its terminal
+export default function DesktopAuthCode() {
+  const router = useRouter();
+  const auth = useAuth();
+  const { session, loading, isAuthenticated } = auth;
+  const [code, setCode] = useState('');
+  const [status, setStatus] = useState<'idle' | 'sending' | 'success' | 'error'>('idle');
+  const [errorMessage, setErrorMessage] = useState('');
+
+  useEffect(() => {
+    if (router.isReady && typeof router.query.code === 'string') {
+      setCode(router.query.code);
+    }
+  }, [router.isReady, router.query.code]);
+
+  const pairNode = async () => {
+    if (!session) return;
+
+    try {
+      setStatus('sending');
+
+      const response = await fetch('/api/pair-node', {
+        method: 'POST',
+        headers: {
+          'Authorization': `Bearer ${session.access_token}`,
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ code }),
+      });
+
+      if (!response.ok) {
+        const body = await response.json().catch(() => ({}));
+        throw new Error(body.error || `Server responded with status: ${response.status}`);
+      }
+
+      setStatus('success');
+      setTimeout(() => {
+        router.push('/dashboard');
+      }, 2000);
+    } catch (error) {
+      console.error('Failed to pair node:', error);
+      setErrorMessage(error instanceof Error ? error.message : 'Unknown error occurred');
+      setStatus('error');
+    }
+  };
+
+  const statusDisplay = (() => {
+    if (loading) {
+      return {
+        icon: <Loader2 className="w-6 h-6 text-blue-600 animate-spin" />,
+        title: 'Checking Authentication',
+        description: 'Verifying your login status...',
+        bgColor: 'bg-blue-100',
+      };
+    }
+    if (!isAuthenticated) {
+      return {
+        icon: <LogIn className="w-6 h-6 text-blue-600" />,
+        title: 'Authentication Required',
+        description: 'Please sign in or create an account to pair your node.',
+        bgColor: 'bg-blue-100',
+      };
+    }
+    switch (status) {
+      case 'sending':
+        return {
+          icon: <Loader2 className="w-6 h-6 text-green-600 animate-spin" />,
+          title: 'Pairing Node',
+          description: 'Linking the node to your account...',
+          bgColor: 'bg-green-100',
+        };
+      case 'success':
+        return {
+          icon: <Check className="w-6 h-6 text-green-600" />,
+          title: 'Success!',
+          description: 'Your node is paired and will appear in your dashboard.',
+          bgColor: 'bg-green-100',
+        };
+      case 'error':
+        return {
+          icon: <AlertCircle className="w-6 h-6 text-red-600" />,
+          title: 'Pairing Failed',
+          description: `Failed to pair node: ${errorMessage}.`,
+          bgColor: 'bg-red-100',
+        };
+      default:
+        return {
+          icon: <Server className="w-6 h-6 text-blue-600" />,
+          title: 'Pair a Node',
+          description: 'Enter the code printed by your node.',
+          bgColor: 'bg-blue-100',
+        };
+    }
+  })();
+
+  return (
+    <div className="min-h-screen bg-gray-50 flex items-center justify-center p-4">
+      <Card className="w-full max-w-md">
+        <CardHeader className="text-center">
+          <div className={`mx-auto w-12 h-12 ${statusDisplay.bgColor} rounded-full flex items-center justify-center mb-4`}>
+            {statusDisplay.icon}
+          </div>
+          <CardTitle>{statusDisplay.title}</CardTitle>
+          <CardDescription>
+            {statusDisplay.description}
+          </CardDescription>
+        </CardHeader>
+        <CardContent>
+          {!loading && !isAuthenticated && (
+            <div className="flex flex-col gap-4 mt-4">
+              <p className="text-sm text-center">Sign in with:</p>
+              <div className="flex justify-center gap-4">
+                <Button
+                  onClick={auth.signInWithDiscord}
+                  className="bg-[#5865F2] hover:bg-[#4752C4] flex items-center gap-2"
+                >
+                  <LogIn className="w-4 h-4" />
+                  Discord
+                </Button>
+                <Button
+                  onClick={() => auth.signInWithGoogle(router.asPath)}
+                  className="bg-white hover:bg-gray-100 text-gray-900 border border-gray-300 flex items-center gap-2"
+                >
+                  <LogIn className="w-4 h-4" />
+                  Google
+                </Button>
+              </div>
+            </div>
+          )}
+
+          {isAuthenticated && (status === 'idle' || status === 'error') && (
+            <div className="flex flex-col gap-4 mt-4">
+              <input
+                value={code}
+                onChange={(e) => setCode(e.target.value.toUpperCase())}
+                placeholder="ABCD-EFGH"
+                className="border border-gray-300 rounded-md px-3 py-2 text-center font-mono tracking-widest"
+              />
+              <Button onClick={pairNode} disabled={!code.trim()}>
+                Pair node
+              </Button>
+            </div>
+          )}
+        </CardContent>
+
+        <CardFooter className="flex justify-center">
+          <p className="text-xs text-gray-500">Codes expire after 10 minutes, the node then prints a new one</p>
+        </CardFooter>
+      </Card>
+    </div>
+  );
+}